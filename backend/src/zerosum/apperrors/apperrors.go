@@ -0,0 +1,76 @@
+package apperrors
+
+import (
+	"errors"
+)
+
+// Stable codes clients can rely on to tell errors apart, sent in the extensions of GraphQL errors
+type Code string
+
+const (
+	INSUFFICIENT_FUNDS Code = "INSUFFICIENT_FUNDS"
+	GAME_CLOSED        Code = "GAME_CLOSED"
+	ALREADY_VOTED      Code = "ALREADY_VOTED"
+	NOT_FOUND          Code = "NOT_FOUND"
+	UNAUTHORIZED       Code = "UNAUTHORIZED"
+	VALIDATION_FAILED  Code = "VALIDATION_FAILED"
+	// Anything that is not an *Error, its message is hidden from clients
+	INTERNAL Code = "INTERNAL"
+)
+
+// An error that is safe to show to clients, along with its code and any details about it
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]interface{}
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Returns a copy of the error with an extra detail, leaving the original untouched so that it can be shared
+func (e *Error) With(key string, value interface{}) *Error {
+	details := make(map[string]interface{}, len(e.Details)+1)
+	for k, v := range e.Details {
+		details[k] = v
+	}
+	details[key] = value
+	return &Error{Code: e.Code, Message: e.Message, Details: details}
+}
+
+// Errors match regardless of their details, so errors.Is(err, ErrX) holds for ErrX.With(...)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && t.Message == e.Message
+}
+
+// Returns the code of the error, or INTERNAL if it is not an *Error
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return INTERNAL
+}
+
+// Convenience constructors for each code
+func NotFound(message string) *Error {
+	return New(NOT_FOUND, message)
+}
+
+func Validation(message string) *Error {
+	return New(VALIDATION_FAILED, message)
+}
+
+func GameClosed(message string) *Error {
+	return New(GAME_CLOSED, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(UNAUTHORIZED, message)
+}
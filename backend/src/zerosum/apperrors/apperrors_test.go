@@ -0,0 +1,36 @@
+package apperrors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWithKeepsOriginal(t *testing.T) {
+	base := New(INSUFFICIENT_FUNDS, "not enough money")
+	detailed := base.With("amount", 100)
+	if base.Details != nil {
+		t.Errorf("Expected original error to have no details, got %v", base.Details)
+	}
+	if detailed.Details["amount"] != 100 {
+		t.Errorf("Expected detail to be set, got %v", detailed.Details)
+	}
+	if !errors.Is(detailed, base) {
+		t.Errorf("Expected detailed error to match the original")
+	}
+	if errors.Is(detailed, New(INSUFFICIENT_FUNDS, "other")) {
+		t.Errorf("Expected errors with different messages not to match")
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	if code := CodeOf(NotFound("no game found")); code != NOT_FOUND {
+		t.Errorf("Expected %s, got %s", NOT_FOUND, code)
+	}
+	if code := CodeOf(fmt.Errorf("wrapped: %w", GameClosed("game has ended"))); code != GAME_CLOSED {
+		t.Errorf("Expected %s for wrapped error, got %s", GAME_CLOSED, code)
+	}
+	if code := CodeOf(errors.New("pq: connection refused")); code != INTERNAL {
+		t.Errorf("Expected %s, got %s", INTERNAL, code)
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/graph-gophers/graphql-go/errors"
-	"log"
 	"net/http"
 	"time"
+	"zerosum/logging"
 	"zerosum/logic"
 	"zerosum/models"
 	"zerosum/repository"
@@ -34,20 +34,21 @@ type loginResponse struct {
 type msi map[string]interface{}
 
 func FbLoginHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 	var loginRequest fbLoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
-		log.Print(err)
+		logger.Error("facebook login failed", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	if err := verifyFbToken(loginRequest); err != nil {
-		log.Print(err)
+		logger.Error("facebook login failed", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	profile, err := getFbProfile(loginRequest.AccessToken)
 	if err != nil {
-		log.Print(err)
+		logger.Error("facebook login failed", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -57,7 +58,7 @@ func FbLoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	signedToken, err := generateSignedUserToken(user)
 	if err != nil {
-		log.Print(err)
+		logger.Error("facebook login failed", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -67,7 +68,7 @@ func FbLoginHandler(w http.ResponseWriter, r *http.Request) {
 		NewUser: isNewUser,
 	})
 	if err != nil {
-		log.Print(err)
+		logger.Error("facebook login failed", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -75,7 +76,7 @@ func FbLoginHandler(w http.ResponseWriter, r *http.Request) {
 		user.Name = profile.Name
 		user.Picture, err = GetFbPicture(profile.Id)
 		if err != nil {
-			log.Print(err)
+			logger.Warn("failed to get facebook picture", "error", err)
 		}
 		repository.UpdateUser(user)
 	}
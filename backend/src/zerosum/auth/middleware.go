@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
-	"log"
 	"net/http"
 	"strings"
+	"zerosum/logging"
 )
 
 type headerBearerExtractor struct{}
@@ -34,7 +34,7 @@ func keyFunc(token *jwt.Token) (interface{}, error) {
 // Handler function accepting a next argument (for use as negroni middleware)
 func TokenAuthNegroniMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	if err := extractAndValidateAuthToken(r); err != nil {
-		log.Print(err)
+		logging.FromContext(r.Context()).Warn("invalid authorization token", "error", err)
 		http.Error(w, "Error processing authorization token", http.StatusUnauthorized)
 		return
 	}
@@ -54,7 +54,11 @@ func extractAndValidateAuthToken(r *http.Request) (error) {
 		return fmt.Errorf("token is invalid")
 	}
 
-	newReq := r.WithContext(context.WithValue(r.Context(), "Id", token.Claims.(*jwt.StandardClaims).Id))
+	userId := token.Claims.(*jwt.StandardClaims).Id
+	ctx := context.WithValue(r.Context(), "Id", userId)
+	// Lines logged for the rest of the request say who made it
+	ctx = logging.NewContext(ctx, logging.FromContext(r.Context()).With("userId", userId))
+	newReq := r.WithContext(ctx)
 	*r = *newReq
 	return nil
 }
@@ -0,0 +1,44 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Longest a single check may take before it counts as failed
+const CHECK_TIMEOUT = 2 * time.Second
+
+// Returns an error if a part of the server is not working
+type Check func(ctx context.Context) error
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Runs every check on each request, answering 200 if they all pass and 503 otherwise, with the result of each check
+// in the body
+func Handler(checks map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), CHECK_TIMEOUT)
+		defer cancel()
+
+		res := response{Status: "ok", Checks: make(map[string]string)}
+		for name, check := range checks {
+			if err := check(ctx); err != nil {
+				res.Status = "unavailable"
+				res.Checks[name] = err.Error()
+			} else {
+				res.Checks[name] = "ok"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if res.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,29 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+
+	rec := httptest.NewRecorder()
+	Handler(map[string]Check{"db": ok, "gameLoop": ok}).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d when every check passes, got %d", http.StatusOK, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	Handler(map[string]Check{"db": down, "gameLoop": ok}).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var res response
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec.Code != http.StatusServiceUnavailable || res.Checks["db"] != "connection refused" || res.Checks["gameLoop"] != "ok" {
+		t.Errorf("Expected failed db check to be reported, got %d %+v", rec.Code, res)
+	}
+}
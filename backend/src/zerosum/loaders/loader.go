@@ -0,0 +1,98 @@
+package loaders
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Fetches the values of a batch of keys in one go, leaving out keys that have no value
+type BatchFunc func(keys []string) (map[string]interface{}, error)
+
+// Caches values by key for the length of a request and fetches them in batches. Keys are queued up as they become
+// known, usually by the resolver of a list, and the first load of any of them fetches every key queued so far.
+// Resolvers run in parallel, so a load may have to wait for a batch already being fetched by another.
+type Loader struct {
+	fetch   BatchFunc
+	mu      sync.Mutex
+	results map[string]*result
+	queued  []string // keys that have not been fetched yet
+}
+
+type result struct {
+	done   chan struct{} // closed once the value is fetched
+	queued bool
+	value  interface{}
+	err    error
+}
+
+func NewLoader(fetch BatchFunc) *Loader {
+	return &Loader{fetch: fetch, results: make(map[string]*result)}
+}
+
+// Adds keys to the next batch, unless they were already loaded or queued
+func (l *Loader) Queue(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		l.queue(key)
+	}
+}
+
+func (l *Loader) queue(key string) *result {
+	if r, ok := l.results[key]; ok {
+		return r
+	}
+	r := &result{done: make(chan struct{}), queued: true}
+	l.results[key] = r
+	l.queued = append(l.queued, key)
+	return r
+}
+
+// Caches a value fetched some other way, such as with the list it came in
+func (l *Loader) Prime(key string, value interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.results[key]; ok {
+		return
+	}
+	r := &result{done: make(chan struct{}), value: value}
+	close(r.done)
+	l.results[key] = r
+}
+
+// Value of the key, or nil if it has none
+func (l *Loader) Load(key string) (value interface{}, err error) {
+	l.mu.Lock()
+	r := l.queue(key)
+	if !r.queued {
+		l.mu.Unlock()
+		<-r.done
+		return r.value, r.err
+	}
+
+	keys := l.queued
+	batch := make([]*result, len(keys))
+	for i, queuedKey := range keys {
+		batch[i] = l.results[queuedKey]
+		batch[i].queued = false
+	}
+	l.queued = nil
+	l.mu.Unlock()
+
+	values, err := l.fetchBatch(keys)
+	for i, queuedKey := range keys {
+		batch[i].value, batch[i].err = values[queuedKey], err
+		close(batch[i].done)
+	}
+	return r.value, r.err
+}
+
+// Fetches a batch, turning a panic into an error so that loads waiting on the batch are not left hanging
+func (l *Loader) fetchBatch(keys []string) (values map[string]interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("fetching batch of %d keys panicked: %v", len(keys), p)
+		}
+	}()
+	return l.fetch(keys)
+}
@@ -0,0 +1,86 @@
+package loaders
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// Loader that records the batches it fetches, with a value for every key except "missing"
+func newCountingLoader() (*Loader, *[][]string) {
+	var mu sync.Mutex
+	var batches [][]string
+	loader := NewLoader(func(keys []string) (map[string]interface{}, error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		values := make(map[string]interface{})
+		for _, key := range keys {
+			if key != "missing" {
+				values[key] = "value of " + key
+			}
+		}
+		return values, nil
+	})
+	return loader, &batches
+}
+
+func TestQueuedKeysFetchedTogether(t *testing.T) {
+	loader, batches := newCountingLoader()
+	loader.Queue("a", "b", "c", "a")
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := loader.Load(key)
+			if err != nil || value != "value of "+key {
+				t.Errorf("Expected value of %s, got %v, %v", key, value, err)
+			}
+		}(key)
+	}
+	wg.Wait()
+	if len(*batches) != 1 || len((*batches)[0]) != 3 {
+		t.Errorf("Expected one batch of 3 keys, got %v", *batches)
+	}
+
+	// Loaded keys are cached, and keys nobody queued are fetched on their own
+	loader.Load("a")
+	if value, _ := loader.Load("missing"); value != nil {
+		t.Errorf("Expected no value for missing key, got %v", value)
+	}
+	if len(*batches) != 2 || len((*batches)[1]) != 1 {
+		t.Errorf("Expected a second batch for the missing key only, got %v", *batches)
+	}
+}
+
+func TestPrimedKeysNotFetched(t *testing.T) {
+	loader, batches := newCountingLoader()
+	loader.Prime("a", "primed")
+	loader.Queue("a")
+	if value, _ := loader.Load("a"); value != "primed" || len(*batches) != 0 {
+		t.Errorf("Expected primed value without a fetch, got %v after %v", value, *batches)
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	failed := errors.New("connection reset")
+	loader := NewLoader(func(keys []string) (map[string]interface{}, error) {
+		return nil, failed
+	})
+	loader.Queue("a", "b")
+	if _, err := loader.Load("a"); err != failed {
+		t.Errorf("Expected error %v, got %v", failed, err)
+	}
+	if _, err := loader.Load("b"); err != failed {
+		t.Errorf("Expected error of the batch for every key in it, got %v", err)
+	}
+
+	panicking := NewLoader(func(keys []string) (map[string]interface{}, error) {
+		panic("oops")
+	})
+	if _, err := panicking.Load("a"); err == nil {
+		t.Errorf("Expected panic to be returned as an error")
+	}
+}
@@ -0,0 +1,331 @@
+package loaders
+
+import (
+	"context"
+	"strings"
+	"zerosum/apperrors"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+// Loaders of everything resolvers look up for each item of a list, shared by all the resolvers of one request
+type Loaders struct {
+	ctx         context.Context // request the batches are fetched for, so that they are traced as part of it
+	userId      string          // user making the request, whose votes Voted looks up
+	users       *Loader
+	games       *Loader
+	options     *Loader
+	gameOptions *Loader
+	totalMoney  *Loader
+	voted       *Loader
+	allocations *Loader // keyed by voteKey
+	numbers     *Loader
+	gameVotes   *Loader
+	hats        *Loader
+}
+
+func New(ctx context.Context, userId string) *Loaders {
+	l := &Loaders{ctx: ctx, userId: userId}
+	l.users = NewLoader(l.fetchUsers)
+	l.games = NewLoader(l.fetchGames)
+	l.options = NewLoader(l.fetchOptions)
+	l.gameOptions = NewLoader(l.fetchGameOptions)
+	l.totalMoney = NewLoader(l.fetchTotalMoney)
+	l.voted = NewLoader(l.fetchVoted)
+	l.allocations = NewLoader(l.fetchAllocations)
+	l.numbers = NewLoader(l.fetchNumberResults)
+	l.gameVotes = NewLoader(l.fetchGameVotes)
+	l.hats = NewLoader(l.fetchHats)
+	return l
+}
+
+// Key of a vote, made of its game and the player who placed it
+func voteKey(gameId string, userId string) string {
+	return gameId + "/" + userId
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, l *Loaders) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+func FromContext(ctx context.Context) (l *Loaders, ok bool) {
+	l, ok = ctx.Value(contextKey{}).(*Loaders)
+	return
+}
+
+/* PRIMING */
+// Caches games loaded as a list and queues up what their resolvers look up, so that it is fetched for the whole list
+// at once
+func (l *Loaders) PrimeGames(games []models.Game) {
+	for _, game := range games {
+		l.games.Prime(game.Id, game)
+	}
+	l.queueGameFields(games)
+}
+
+func (l *Loaders) queueGameFields(games []models.Game) {
+	for _, game := range games {
+		l.users.Queue(game.UserId)
+		l.gameOptions.Queue(game.Id)
+		l.totalMoney.Queue(game.Id)
+		if l.userId != "" {
+			l.voted.Queue(game.Id)
+		}
+		// Results are only shown once a game is resolved
+		if game.Resolved && game.GameMode == models.LOWEST_UNIQUE {
+			l.numbers.Queue(game.Id)
+		}
+		if game.Resolved && game.GameMode == models.BEAUTY_CONTEST {
+			l.gameVotes.Queue(game.Id)
+		}
+	}
+}
+
+// Queues up the games, options and allocations of votes loaded as a list
+func (l *Loaders) PrimeVotes(votes []models.Vote) {
+	for _, vote := range votes {
+		l.games.Queue(vote.GameId)
+		if vote.OptionId != "" {
+			l.options.Queue(vote.OptionId)
+		}
+		l.allocations.Queue(voteKey(vote.GameId, vote.UserId))
+	}
+}
+
+func (l *Loaders) QueueUsers(ids ...string) {
+	l.users.Queue(ids...)
+}
+
+func (l *Loaders) QueueGames(ids ...string) {
+	l.games.Queue(ids...)
+}
+
+func (l *Loaders) QueueHats(ids ...string) {
+	l.hats.Queue(ids...)
+}
+
+/* LOADS */
+func (l *Loaders) User(id string) (user models.User, err error) {
+	value, err := l.users.Load(id)
+	if err != nil {
+		return
+	}
+	if value == nil {
+		err = apperrors.NotFound("no user found")
+		return
+	}
+	user = value.(models.User)
+	return
+}
+
+func (l *Loaders) Game(id string) (game models.Game, err error) {
+	value, err := l.games.Load(id)
+	if err != nil {
+		return
+	}
+	if value == nil {
+		err = apperrors.NotFound("no game found")
+		return
+	}
+	game = value.(models.Game)
+	return
+}
+
+func (l *Loaders) Option(id string) (option models.Option, err error) {
+	value, err := l.options.Load(id)
+	if err != nil {
+		return
+	}
+	if value == nil {
+		err = apperrors.NotFound("no option found")
+		return
+	}
+	option = value.(models.Option)
+	return
+}
+
+func (l *Loaders) GameOptions(gameId string) (options []models.Option, err error) {
+	value, err := l.gameOptions.Load(gameId)
+	if value != nil {
+		options = value.([]models.Option)
+	}
+	return
+}
+
+// Money staked in the game across all its votes
+func (l *Loaders) TotalMoney(gameId string) (total int32, err error) {
+	value, err := l.totalMoney.Load(gameId)
+	if value != nil {
+		total = value.(int32)
+	}
+	return
+}
+
+// Whether the user making the request has voted in the game
+func (l *Loaders) Voted(gameId string) (voted bool, err error) {
+	if l.userId == "" {
+		return
+	}
+	value, err := l.voted.Load(gameId)
+	voted = value != nil
+	return
+}
+
+// Allocations of a vote, empty for votes placed before they could be split
+func (l *Loaders) Allocations(gameId string, userId string) (allocations []models.Allocation, err error) {
+	value, err := l.allocations.Load(voteKey(gameId, userId))
+	if value != nil {
+		allocations = value.([]models.Allocation)
+	}
+	return
+}
+
+func (l *Loaders) NumberResults(gameId string) (numberResults []models.NumberResult, err error) {
+	value, err := l.numbers.Load(gameId)
+	if value != nil {
+		numberResults = value.([]models.NumberResult)
+	}
+	return
+}
+
+func (l *Loaders) GameVotes(gameId string) (votes []models.Vote, err error) {
+	value, err := l.gameVotes.Load(gameId)
+	if value != nil {
+		votes = value.([]models.Vote)
+	}
+	return
+}
+
+func (l *Loaders) Hat(id string) (hat models.Hat, err error) {
+	value, err := l.hats.Load(id)
+	if err != nil {
+		return
+	}
+	if value == nil {
+		err = apperrors.NotFound("no hat found")
+		return
+	}
+	hat = value.(models.Hat)
+	return
+}
+
+/* BATCHES */
+func (l *Loaders) fetchUsers(ids []string) (values map[string]interface{}, err error) {
+	users, err := repository.QueryUsersByIds(l.ctx, ids)
+	values = make(map[string]interface{})
+	for _, user := range users {
+		values[user.Id] = user
+	}
+	return
+}
+
+func (l *Loaders) fetchGames(ids []string) (values map[string]interface{}, err error) {
+	games, err := repository.QueryGamesByIds(l.ctx, ids)
+	values = make(map[string]interface{})
+	for _, game := range games {
+		values[game.Id] = game
+	}
+	// Games loaded for a list of votes or transactions are resolved as a list too
+	l.queueGameFields(games)
+	return
+}
+
+func (l *Loaders) fetchOptions(ids []string) (values map[string]interface{}, err error) {
+	options, err := repository.QueryOptionsByIds(l.ctx, ids)
+	values = make(map[string]interface{})
+	for _, option := range options {
+		values[option.Id] = option
+	}
+	return
+}
+
+func (l *Loaders) fetchGameOptions(gameIds []string) (values map[string]interface{}, err error) {
+	options, err := repository.QueryOptionsOfGames(l.ctx, gameIds)
+	grouped := make(map[string][]models.Option)
+	for _, option := range options {
+		grouped[option.GameId] = append(grouped[option.GameId], option)
+		l.options.Prime(option.Id, option)
+	}
+	values = make(map[string]interface{})
+	for _, gameId := range gameIds {
+		values[gameId] = grouped[gameId]
+	}
+	return
+}
+
+func (l *Loaders) fetchTotalMoney(gameIds []string) (values map[string]interface{}, err error) {
+	sums, err := repository.SumGameVotes(l.ctx, gameIds)
+	values = make(map[string]interface{})
+	for gameId, sum := range sums {
+		values[gameId] = sum
+	}
+	return
+}
+
+func (l *Loaders) fetchVoted(gameIds []string) (values map[string]interface{}, err error) {
+	votedIds, err := repository.QueryVotedGameIds(l.ctx, l.userId, gameIds)
+	values = make(map[string]interface{})
+	for _, gameId := range votedIds {
+		values[gameId] = true
+	}
+	return
+}
+
+func (l *Loaders) fetchAllocations(keys []string) (values map[string]interface{}, err error) {
+	var gameIds, userIds []string
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", 2)
+		gameIds = append(gameIds, parts[0])
+		userIds = append(userIds, parts[1])
+	}
+	// Allocations of every user in every game are fetched, and only those of the votes asked for are kept
+	allocations, err := repository.QueryAllocationsOfVotes(l.ctx, gameIds, userIds)
+	grouped := make(map[string][]models.Allocation)
+	for _, allocation := range allocations {
+		key := voteKey(allocation.GameId, allocation.UserId)
+		grouped[key] = append(grouped[key], allocation)
+	}
+	values = make(map[string]interface{})
+	for _, key := range keys {
+		values[key] = grouped[key]
+	}
+	return
+}
+
+func (l *Loaders) fetchNumberResults(gameIds []string) (values map[string]interface{}, err error) {
+	numberResults, err := repository.QueryNumberResultsOfGames(l.ctx, gameIds)
+	grouped := make(map[string][]models.NumberResult)
+	for _, numberResult := range numberResults {
+		grouped[numberResult.GameId] = append(grouped[numberResult.GameId], numberResult)
+	}
+	values = make(map[string]interface{})
+	for _, gameId := range gameIds {
+		values[gameId] = grouped[gameId]
+	}
+	return
+}
+
+func (l *Loaders) fetchGameVotes(gameIds []string) (values map[string]interface{}, err error) {
+	votes, err := repository.QueryVotesOfGames(l.ctx, gameIds)
+	grouped := make(map[string][]models.Vote)
+	for _, vote := range votes {
+		grouped[vote.GameId] = append(grouped[vote.GameId], vote)
+		l.users.Queue(vote.UserId)
+	}
+	values = make(map[string]interface{})
+	for _, gameId := range gameIds {
+		values[gameId] = grouped[gameId]
+	}
+	return
+}
+
+func (l *Loaders) fetchHats(ids []string) (values map[string]interface{}, err error) {
+	hats, err := repository.QueryHatsByIds(l.ctx, ids)
+	values = make(map[string]interface{})
+	for _, hat := range hats {
+		values[hat.Id] = hat
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"zerosum/tracing"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+func (level Level) String() string {
+	switch level {
+	case DEBUG:
+		return "debug"
+	case INFO:
+		return "info"
+	case WARN:
+		return "warn"
+	}
+	return "error"
+}
+
+// Parses a level from its name, falling back to INFO for names it does not know
+func ParseLevel(name string) Level {
+	for _, level := range []Level{DEBUG, INFO, WARN, ERROR} {
+		if strings.EqualFold(name, level.String()) {
+			return level
+		}
+	}
+	return INFO
+}
+
+// Writes log lines as JSON objects, one per line, with the time, level, message and the logger's fields followed by
+// the fields of the line itself
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	fields []interface{} // alternating keys and values
+}
+
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{mu: &sync.Mutex{}, out: out, level: level}
+}
+
+var defaultLogger = New(os.Stderr, ParseLevel(os.Getenv("LOG_LEVEL")))
+
+// Logger for code that does not run on behalf of a request
+func Default() *Logger {
+	return defaultLogger
+}
+
+func SetDefault(logger *Logger) {
+	defaultLogger = logger
+}
+
+// Returns a logger that adds the given alternating keys and values to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append([]interface{}(nil), l.fields...), keyvals...)
+	return &child
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.write(DEBUG, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.write(INFO, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.write(WARN, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.write(ERROR, msg, keyvals)
+}
+
+func (l *Logger) write(level Level, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	writeField(&buf, "time", time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(",")
+	writeField(&buf, "level", level.String())
+	buf.WriteString(",")
+	writeField(&buf, "msg", msg)
+	fields := append(append([]interface{}(nil), l.fields...), keyvals...)
+	for i := 0; i < len(fields); i += 2 {
+		key, ok := fields[i].(string)
+		if !ok {
+			key = fmt.Sprint(fields[i])
+		}
+		var value interface{} = "MISSING"
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		buf.WriteString(",")
+		writeField(&buf, key, value)
+	}
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+func writeField(buf *bytes.Buffer, key string, value interface{}) {
+	// Errors and other values without a JSON form of their own are written as their text
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case json.Marshaler:
+	case fmt.Stringer:
+		value = v.String()
+	}
+	keyJSON, _ := json.Marshal(key)
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		valueJSON, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(keyJSON)
+	buf.WriteString(":")
+	buf.Write(valueJSON)
+}
+
+/* CONTEXT */
+type contextKey struct{}
+
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// Logger carried by the context, or the default logger if there is none. Lines are tagged with the id of the trace
+// the context is part of, if any.
+func FromContext(ctx context.Context) *Logger {
+	logger, ok := ctx.Value(contextKey{}).(*Logger)
+	if !ok {
+		logger = defaultLogger
+	}
+	if traceId := tracing.TraceId(ctx); traceId != "" {
+		logger = logger.With("traceId", traceId)
+	}
+	return logger
+}
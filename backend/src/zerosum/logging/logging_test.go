@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/urfave/negroni"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decodeLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("Expected line to be JSON, got %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestLoggerWritesJSON(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, INFO).With("requestId", "abc")
+	logger.Error("failed", "error", errors.New("boom"), "count", 3, "dangling")
+
+	lines := decodeLines(t, &out)
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 line, got %d", len(lines))
+	}
+	line := lines[0]
+	if line["level"] != "error" || line["msg"] != "failed" || line["time"] == nil {
+		t.Errorf("Expected level, message and time, got %v", line)
+	}
+	if line["requestId"] != "abc" || line["error"] != "boom" || line["count"] != float64(3) {
+		t.Errorf("Expected fields of logger and line, got %v", line)
+	}
+	if line["dangling"] != "MISSING" {
+		t.Errorf("Expected key without value to be marked, got %v", line)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, WARN)
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	lines := decodeLines(t, &out)
+	if len(lines) != 1 || lines[0]["msg"] != "warn" {
+		t.Errorf("Expected only lines at or above the level, got %v", lines)
+	}
+
+	if ParseLevel("DEBUG") != DEBUG || ParseLevel("unknown") != INFO {
+		t.Errorf("Expected levels to be parsed by name")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Default() {
+		t.Errorf("Expected default logger without one in the context")
+	}
+	var out bytes.Buffer
+	ctx := NewContext(context.Background(), New(&out, INFO).With("requestId", "abc"))
+	FromContext(ctx).Info("hello")
+	lines := decodeLines(t, &out)
+	if len(lines) != 1 || lines[0]["requestId"] != "abc" {
+		t.Errorf("Expected logger from the context, got %v", lines)
+	}
+}
+
+func TestMiddlewareRequestId(t *testing.T) {
+	var out bytes.Buffer
+	previous := Default()
+	SetDefault(New(&out, INFO))
+	defer SetDefault(previous)
+
+	n := negroni.New(negroni.HandlerFunc(NegroniMiddleware))
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handling")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	// Ids from the client are kept as long as they are safe to log, otherwise a new one is generated
+	for _, test := range []struct {
+		sent string
+		kept bool
+	}{{"proxy-1", true}, {"", false}, {"bad\nid", false}} {
+		out.Reset()
+		req := httptest.NewRequest("GET", "/gql", nil)
+		if test.sent != "" {
+			req.Header.Set(REQUEST_ID_HEADER, test.sent)
+		}
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, req)
+
+		requestId := w.Header().Get(REQUEST_ID_HEADER)
+		if requestId == "" || (requestId == test.sent) != test.kept {
+			t.Errorf("Expected id %q to be kept: %v, got %q", test.sent, test.kept, requestId)
+		}
+		lines := decodeLines(t, &out)
+		if len(lines) != 2 || lines[0]["requestId"] != requestId || lines[1]["requestId"] != requestId {
+			t.Fatalf("Expected every line to have the request id, got %v", lines)
+		}
+		if lines[1]["msg"] != "request served" || lines[1]["status"] != float64(http.StatusTeapot) {
+			t.Errorf("Expected request to be logged with its status, got %v", lines[1])
+		}
+	}
+}
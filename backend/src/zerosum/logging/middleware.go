@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"github.com/segmentio/ksuid"
+	"github.com/urfave/negroni"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+// Request ids passed on by a proxy are kept as long as they are safe to log
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Gives each request an id, sent back in a header, and a logger that adds it to every line logged for the request.
+// Each request is logged once it has been served.
+func NegroniMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	requestId := r.Header.Get(REQUEST_ID_HEADER)
+	if !validRequestId.MatchString(requestId) {
+		requestId = ksuid.New().String()
+	}
+	w.Header().Set(REQUEST_ID_HEADER, requestId)
+	logger := Default().With("requestId", requestId)
+
+	ctx := NewContext(r.Context(), logger)
+	start := time.Now()
+	next(w, r.WithContext(ctx))
+
+	status := 0
+	if rw, ok := w.(negroni.ResponseWriter); ok {
+		status = rw.Status()
+	}
+	FromContext(ctx).Info("request served", "method", r.Method,
+		"path", r.URL.Path, "status", status, "duration", time.Since(start).Seconds())
+}
@@ -0,0 +1,95 @@
+package logic
+
+import (
+	"math"
+	"zerosum/apperrors"
+	"zerosum/models"
+)
+
+const (
+	DEFAULT_RANGE_MIN       = 0
+	DEFAULT_RANGE_MAX       = 100
+	DEFAULT_TARGET_FRACTION = 2.0 / 3.0
+)
+
+// Keynesian beauty contest, players pick a number in a range and whoever is closest to a fraction of the average wins
+type beautyContestMode struct{}
+
+func (m beautyContestMode) Name() models.GameMode {
+	return models.BEAUTY_CONTEST
+}
+
+func (m beautyContestMode) ValidateGame(game *models.Game) (err error) {
+	if len(game.Options) > 0 {
+		err = apperrors.Validation("numeric game does not take options")
+		return
+	}
+	// Fall back to the classic "guess 2/3 of the average" game when the creator leaves out the settings
+	if game.RangeMin == 0 && game.RangeMax == 0 {
+		game.RangeMin = DEFAULT_RANGE_MIN
+		game.RangeMax = DEFAULT_RANGE_MAX
+	}
+	if game.TargetFraction == 0 {
+		game.TargetFraction = DEFAULT_TARGET_FRACTION
+	}
+
+	if game.RangeMin >= game.RangeMax {
+		err = apperrors.Validation("invalid range specified")
+	} else if game.TargetFraction < 0 {
+		err = apperrors.Validation("invalid target fraction specified")
+	}
+	return
+}
+
+func (m beautyContestMode) ValidateVote(game *models.Game, vote *models.Vote) (err error) {
+	if vote.Number == nil {
+		err = apperrors.Validation("no number specified")
+	} else if vote.OptionId != "" || len(vote.Allocations) > 0 {
+		err = apperrors.Validation("game does not accept options")
+	} else if math.IsNaN(*vote.Number) || *vote.Number < game.RangeMin || *vote.Number > game.RangeMax {
+		err = apperrors.Validation("number out of range")
+	}
+	return
+}
+
+func (m beautyContestMode) SelectWinners(game *models.Game, options []models.Option, votes []models.Vote) (outcome Outcome, err error) {
+	outcome.Winners = make(map[string]bool)
+	outcome.Distances = make(map[string]float64)
+
+	var sum float64
+	var count int
+	for _, vote := range votes {
+		if vote.Number != nil {
+			sum += *vote.Number
+			count += 1
+		}
+	}
+	if count == 0 {
+		return
+	}
+	target := game.TargetFraction * sum / float64(count)
+	outcome.TargetValue = &target
+
+	// Everyone tied for the closest number wins
+	closest := math.Inf(1)
+	for _, vote := range votes {
+		if vote.Number == nil {
+			continue
+		}
+		distance := math.Abs(*vote.Number - target)
+		outcome.Distances[vote.UserId] = distance
+		if distance < closest {
+			closest = distance
+		}
+	}
+	for userId, distance := range outcome.Distances {
+		if distance == closest {
+			outcome.Winners[userId] = true
+		}
+	}
+	return
+}
+
+func (m beautyContestMode) ComputePayouts(game *models.Game, outcome Outcome, votes []models.Vote) []Payout {
+	return splitPot(outcome, votes)
+}
@@ -0,0 +1,85 @@
+package logic
+
+import (
+	"math"
+	"testing"
+	"zerosum/models"
+)
+
+func number(n float64) *float64 {
+	return &n
+}
+
+func TestBeautyContestValidateGame(t *testing.T) {
+	mode, _ := GetGameMode(models.BEAUTY_CONTEST)
+	game := &models.Game{}
+	if err := mode.ValidateGame(game); err != nil {
+		t.Fatalf("Unexpected error for default settings: %v", err)
+	}
+	if game.RangeMin != DEFAULT_RANGE_MIN || game.RangeMax != DEFAULT_RANGE_MAX ||
+		game.TargetFraction != DEFAULT_TARGET_FRACTION {
+		t.Errorf("Expected default settings, got %+v", game)
+	}
+	if err := mode.ValidateGame(&models.Game{RangeMin: 10, RangeMax: 5}); err == nil {
+		t.Errorf("Expected error for empty range")
+	}
+	if err := mode.ValidateGame(&models.Game{Options: []models.Option{{Body: "1"}}}); err == nil {
+		t.Errorf("Expected error for game with options")
+	}
+}
+
+func TestBeautyContestValidateVote(t *testing.T) {
+	mode, _ := GetGameMode(models.BEAUTY_CONTEST)
+	game := &models.Game{RangeMin: 0, RangeMax: 100}
+	if err := mode.ValidateVote(game, &models.Vote{Number: number(50)}); err != nil {
+		t.Errorf("Unexpected error for number in range: %v", err)
+	}
+	if err := mode.ValidateVote(game, &models.Vote{Number: number(101)}); err == nil {
+		t.Errorf("Expected error for number out of range")
+	}
+	if err := mode.ValidateVote(game, &models.Vote{OptionId: "a"}); err == nil {
+		t.Errorf("Expected error for vote without number")
+	}
+}
+
+func TestBeautyContestMode(t *testing.T) {
+	game := &models.Game{Stakes: models.NO_LIMIT, RangeMin: 0, RangeMax: 100, TargetFraction: 2.0 / 3.0}
+	votes := []models.Vote{
+		{UserId: "u1", Number: number(30), Money: 100},
+		{UserId: "u2", Number: number(60), Money: 100},
+		{UserId: "u3", Number: number(90), Money: 100},
+	}
+	mode, _ := GetGameMode(models.BEAUTY_CONTEST)
+	outcome, err := mode.SelectWinners(game, nil, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+	// Average is 60 so the target is 40
+	if outcome.TargetValue == nil || math.Abs(*outcome.TargetValue-40) > 1e-9 {
+		t.Fatalf("Expected target value 40, got %v", outcome.TargetValue)
+	}
+	if math.Abs(outcome.Distances["u3"]-50) > 1e-9 {
+		t.Errorf("Expected u3 to be 50 away, got %v", outcome.Distances["u3"])
+	}
+	if !outcome.Winners["u1"] || outcome.Winners["u2"] || outcome.Winners["u3"] {
+		t.Errorf("Expected only u1 to win, got %+v", outcome.Winners)
+	}
+
+	payouts := payoutsByUser(mode.ComputePayouts(game, outcome, votes))
+	if p := payouts["u1"]; !p.Win || p.Amount != 300 {
+		t.Errorf("Expected u1 to win the pot of 300, got %+v", p)
+	}
+}
+
+func TestBeautyContestTie(t *testing.T) {
+	game := &models.Game{Stakes: models.NO_LIMIT, RangeMin: 0, RangeMax: 100, TargetFraction: 1}
+	votes := []models.Vote{
+		{UserId: "u1", Number: number(40), Money: 100},
+		{UserId: "u2", Number: number(60), Money: 300},
+	}
+	mode, _ := GetGameMode(models.BEAUTY_CONTEST)
+	outcome, _ := mode.SelectWinners(game, nil, votes)
+	if !outcome.Winners["u1"] || !outcome.Winners["u2"] {
+		t.Errorf("Expected both players to win, got %+v", outcome.Winners)
+	}
+}
@@ -0,0 +1,27 @@
+package logic
+
+import "time"
+
+// Source of time for the game controller, so that tests can control when games end
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	// Returns false if the timer already fired or was stopped
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Clock backed by the time package
+var RealClock Clock = realClock{}
@@ -0,0 +1,75 @@
+package logic
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock that only moves when told to, firing timers synchronously as their time comes
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	f        func()
+	done     bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{clock: c, deadline: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Moves the clock forward, firing every timer due by then in the order of their deadlines
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	var pending []*fakeTimer
+	for _, timer := range c.timers {
+		if timer.done {
+			continue
+		}
+		if timer.deadline.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.done = true
+			due = append(due, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].deadline.Before(due[j].deadline) })
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.done {
+		return false
+	}
+	t.done = true
+	return true
+}
@@ -0,0 +1,291 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+const PARALLEL_REQUESTS = 20
+
+var testDbOnce sync.Once
+var testDbErr error
+
+// Connects to the local test database, skipping tests that need it when it is not available
+func requireTestDB(t *testing.T) {
+	testDbOnce.Do(func() {
+		testDbErr = repository.InitDB("zerosumtest")
+		if testDbErr == nil {
+			testDbErr = SetUpHats()
+		}
+	})
+	if testDbErr != nil {
+		t.Skipf("Test database not available: %v", testDbErr)
+	}
+}
+
+func createTestUser(t *testing.T) models.User {
+	user, err := repository.GetOrCreateUser(models.User{FbId: fmt.Sprintf("test-%d", time.Now().UnixNano())})
+	if err == nil {
+		err = FormHatRelations(user.Id)
+	}
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	return user
+}
+
+func createTestGame(t *testing.T, userId string) models.Game {
+	game, err := repository.CreateGame(models.Game{
+		Topic:           "Concurrency",
+		UserId:          userId,
+		StartTime:       time.Now(),
+		EndTime:         time.Now().Add(time.Hour),
+		Stakes:          models.NO_LIMIT,
+		GameMode:        models.BEAUTY_CONTEST,
+		RangeMax:        100,
+		TargetFraction:  0.5,
+		MinParticipants: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	return game
+}
+
+// Runs fn from many goroutines at once, returning how many calls succeeded
+func runInParallel(fn func(i int) error) (succeeded int) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := 0; i < PARALLEL_REQUESTS; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if fn(i) == nil {
+				mu.Lock()
+				succeeded += 1
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return
+}
+
+func checkLedgerBalance(t *testing.T, user models.User) {
+	balance, err := repository.QueryAccountBalance(models.UserAccount(user.Id))
+	if err != nil {
+		t.Fatalf("Failed to query ledger balance: %v", err)
+	}
+	if balance != user.MoneyTotal {
+		t.Errorf("Expected ledger balance %d to match money total %d", balance, user.MoneyTotal)
+	}
+}
+
+func TestConcurrentStakesCannotOverspend(t *testing.T) {
+	requireTestDB(t)
+	user := createTestUser(t)
+	defer repository.DeleteUser(user)
+	host := createTestUser(t)
+	defer repository.DeleteUser(host)
+
+	// Every player can only afford a quarter of the stakes placed in parallel
+	stake := int32(repository.STARTING_MONEY / (PARALLEL_REQUESTS / 4))
+	var games []models.Game
+	for i := 0; i < PARALLEL_REQUESTS; i++ {
+		games = append(games, createTestGame(t, host.Id))
+	}
+	succeeded := runInParallel(func(i int) error {
+		return repository.Transaction(context.Background(), func(tx *repository.Tx) error {
+			return stakeMoney(tx, user.Id, games[i].Id, stake)
+		})
+	})
+	if succeeded != PARALLEL_REQUESTS/4 {
+		t.Errorf("Expected %d stakes to succeed, got %d", PARALLEL_REQUESTS/4, succeeded)
+	}
+
+	user, _ = repository.QueryUser(context.Background(), models.User{Id: user.Id})
+	if user.MoneyTotal != 0 {
+		t.Errorf("Expected balance to be spent exactly, got %d", user.MoneyTotal)
+	}
+	checkLedgerBalance(t, user)
+}
+
+func TestConcurrentVotesOnGame(t *testing.T) {
+	requireTestDB(t)
+	host := createTestUser(t)
+	defer repository.DeleteUser(host)
+	game := createTestGame(t, host.Id)
+
+	var users []models.User
+	for i := 0; i < PARALLEL_REQUESTS; i++ {
+		user := createTestUser(t)
+		defer repository.DeleteUser(user)
+		users = append(users, user)
+	}
+	succeeded := runInParallel(func(i int) error {
+		number := float64(i)
+		return PlaceVote(context.Background(), models.Vote{GameId: game.Id, UserId: users[i].Id, Number: &number, Money: 100}, "")
+	})
+	if succeeded != PARALLEL_REQUESTS {
+		t.Fatalf("Expected every vote to be placed, got %d", succeeded)
+	}
+
+	// Settling the game from several goroutines at once only pays out once
+	runInParallel(func(i int) error {
+		return ResolveGame(context.Background(), game.Id)
+	})
+	total := int32(0)
+	for _, user := range users {
+		user, _ = repository.QueryUser(context.Background(), models.User{Id: user.Id})
+		total += user.MoneyTotal
+		if user.GamesPlayed != 1 || user.Experience != VOTE_EXP+int(user.GamesWon)*WIN_EXP {
+			t.Errorf("Expected stats of one game, got %+v", user)
+		}
+		checkLedgerBalance(t, user)
+	}
+	// With a single winner taking the whole pot there is nothing left to round
+	escrow, _ := repository.QueryAccountBalance(models.GameAccount(game.Id))
+	if total != PARALLEL_REQUESTS*repository.STARTING_MONEY || escrow != 0 {
+		t.Errorf("Expected no money lost in settlement, got %d in total and %d left in escrow", total, escrow)
+	}
+}
+
+func TestConcurrentExpAndStats(t *testing.T) {
+	requireTestDB(t)
+	user := createTestUser(t)
+	defer repository.DeleteUser(user)
+
+	runInParallel(func(i int) error {
+		return repository.Transaction(context.Background(), func(tx *repository.Tx) error {
+			err := allocateExp(tx, user.Id, HOST_EXP)
+			if err == nil {
+				err = allocateWinOrLoss(tx, user.Id, i%2 == 0)
+			}
+			return err
+		})
+	})
+
+	user, _ = repository.QueryUser(context.Background(), models.User{Id: user.Id})
+	if user.Experience != PARALLEL_REQUESTS*HOST_EXP {
+		t.Errorf("Expected %d exp, got %d", PARALLEL_REQUESTS*HOST_EXP, user.Experience)
+	}
+	if user.GamesPlayed != PARALLEL_REQUESTS || user.GamesWon != PARALLEL_REQUESTS/2 || user.WinRate != 0.5 {
+		t.Errorf("Expected half of %d games won, got %+v", PARALLEL_REQUESTS, user)
+	}
+}
+
+func TestConcurrentRetriesWithIdempotencyKey(t *testing.T) {
+	requireTestDB(t)
+	host := createTestUser(t)
+	defer repository.DeleteUser(host)
+	user := createTestUser(t)
+	defer repository.DeleteUser(user)
+	game := createTestGame(t, host.Id)
+
+	// Every retry succeeds, but only the first one places the vote
+	number := float64(50)
+	succeeded := runInParallel(func(i int) error {
+		return PlaceVote(context.Background(), models.Vote{GameId: game.Id, UserId: user.Id, Number: &number, Money: 100}, "retry-key")
+	})
+	if succeeded != PARALLEL_REQUESTS {
+		t.Errorf("Expected every retry to succeed, got %d", succeeded)
+	}
+	user, _ = repository.QueryUser(context.Background(), models.User{Id: user.Id})
+	if user.MoneyTotal != repository.STARTING_MONEY-100 || user.Experience != VOTE_EXP {
+		t.Errorf("Expected vote to be placed once, got %+v", user)
+	}
+	checkLedgerBalance(t, user)
+
+	// Retries are recognised before the vote is validated, so they are answered even once the game has ended
+	game.EndTime = time.Now().Add(-time.Minute)
+	if err := repository.UpdateGame(game); err != nil {
+		t.Fatalf("Failed to end game: %v", err)
+	}
+	if placed, err := VotePlaced(context.Background(), user.Id, game.Id, "retry-key"); !placed || err != nil {
+		t.Errorf("Expected retry to find the placed vote, got %v, %v", placed, err)
+	}
+
+	// The same key cannot be used for another game
+	other := createTestGame(t, host.Id)
+	err := PlaceVote(context.Background(), models.Vote{GameId: other.Id, UserId: user.Id, Number: &number, Money: 100}, "retry-key")
+	if err != ErrIdempotencyKeyReused {
+		t.Errorf("Expected error %v, got %v", ErrIdempotencyKeyReused, err)
+	}
+}
+
+func TestConcurrentResolutionClaims(t *testing.T) {
+	requireTestDB(t)
+	host := createTestUser(t)
+	defer repository.DeleteUser(host)
+	game := createTestGame(t, host.Id)
+	now := time.Now()
+	if err := repository.Transaction(context.Background(), func(tx *repository.Tx) error {
+		return tx.EnqueueResolutionJob(game.Id, now)
+	}); err != nil {
+		t.Fatalf("Failed to queue resolution job: %v", err)
+	}
+
+	// Servers claiming the same job at once only let one of them run it
+	var mu sync.Mutex
+	claims := 0
+	runInParallel(func(i int) error {
+		_, claimed, err := repository.ClaimResolutionJob(fmt.Sprintf("server-%d", i), game.Id, now, RESOLUTION_LEASE)
+		if claimed {
+			mu.Lock()
+			claims += 1
+			mu.Unlock()
+		}
+		return err
+	})
+	if claims != 1 {
+		t.Errorf("Expected job to be claimed once, got %d", claims)
+	}
+
+	// Once the lease runs out, as when the server holding it dies, another server takes the job over
+	later := now.Add(RESOLUTION_LEASE + time.Second)
+	if _, claimed, _ := repository.ClaimResolutionJob("other", game.Id, later, RESOLUTION_LEASE); !claimed {
+		t.Errorf("Expected expired lease to be taken over")
+	}
+}
+
+func TestConcurrentRetriesOfDeadResolution(t *testing.T) {
+	requireTestDB(t)
+	host := createTestUser(t)
+	defer repository.DeleteUser(host)
+	game := createTestGame(t, host.Id)
+	now := time.Now()
+	if err := repository.Transaction(context.Background(), func(tx *repository.Tx) error {
+		return tx.EnqueueResolutionJob(game.Id, now)
+	}); err != nil {
+		t.Fatalf("Failed to queue resolution job: %v", err)
+	}
+	if _, err := RetryResolution(context.Background(), game.Id); err == nil {
+		t.Errorf("Expected pending job not to be retried")
+	}
+
+	job, claimed, err := repository.ClaimResolutionJob("server", game.Id, now, RESOLUTION_LEASE)
+	if !claimed || err != nil {
+		t.Fatalf("Failed to claim resolution job: %v", err)
+	}
+	job.Status = models.DEAD
+	if err := repository.ReleaseResolutionJob(job, "server"); err != nil {
+		t.Fatalf("Failed to release resolution job: %v", err)
+	}
+
+	// Admins retrying the same dead job at once only put it back in the queue once
+	retries := runInParallel(func(i int) error {
+		_, err := RetryResolution(context.Background(), game.Id)
+		return err
+	})
+	if retries != 1 {
+		t.Errorf("Expected job to be retried once, got %d", retries)
+	}
+	if job, _ := repository.QueryResolutionJob(context.Background(), game.Id); job.Status != models.PENDING || job.Attempts != 0 {
+		t.Errorf("Expected retried job to be pending with fresh attempts, got %+v", job)
+	}
+}
@@ -2,36 +2,127 @@ package logic
 
 import (
 	"container/heap"
-	"log"
-	"time"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"zerosum/logging"
 	"zerosum/models"
 )
 
-const TIME_FORMAT = "2-Jan-2006 15:04:05"
-
 type GameController struct {
+	clock          Clock
+	resolve        func(gameId string) // called from the game loop as each game ends, so it must not block
 	incomingGames  chan *models.Game
 	finishedGames  chan *models.Game
+	removedGames   chan string
+	pings          chan chan struct{}
+	stop           chan struct{}
+	stopped        chan struct{}
 	queue          TimedGameQueue
 	nextEndingGame *models.Game
-	timer          *time.Timer
+	timer          Timer
+	scheduled      int64 // games waiting to end, kept up to date by the game loop for other goroutines to read
+	// Games added before the loop starts go straight into the queue, as there may be more than incomingGames holds
+	mu      sync.Mutex
+	started bool
 }
 
-var Controller *GameController
+// Resolves games as they end, started by main once the database is set up
+var Controller = NewGameController(RealClock, func(gameId string) {
+	runInBackground(func() {
+		resolveEndedGame(gameId)
+	})
+})
 
-func init() {
-	Controller = &GameController{
+func NewGameController(clock Clock, resolve func(gameId string)) *GameController {
+	return &GameController{
+		clock:         clock,
+		resolve:       resolve,
 		incomingGames: make(chan *models.Game, 100),
 		finishedGames: make(chan *models.Game, 100),
+		removedGames:  make(chan string, 100),
+		pings:         make(chan chan struct{}),
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 		queue:         make(TimedGameQueue, 0),
 	}
-	go Controller.gameLoop()
+}
+
+// Starts the game loop, games added before then are scheduled as soon as it starts
+func (c *GameController) Start() {
+	c.mu.Lock()
+	c.started = true
+	c.mu.Unlock()
+	go c.gameLoop()
+}
+
+// Stops the game loop and the timer of the next game to end, returning once the loop has exited. Games still
+// waiting to end are left to be restored the next time the server starts.
+func (c *GameController) Stop() {
+	close(c.stop)
+	<-c.stopped
 }
 
 func (c *GameController) AddGame(game *models.Game) {
+	c.mu.Lock()
+	if !c.started {
+		heap.Push(&c.queue, game)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
 	c.incomingGames <- game
 }
 
+// Returns an error unless the game loop responds before the context is done
+func (c *GameController) Ping(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case c.pings <- reply:
+	case <-ctx.Done():
+		return errors.New("game loop not responding")
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return errors.New("game loop not responding")
+	}
+}
+
+// Number of games waiting to end
+func (c *GameController) Scheduled() int {
+	return int(atomic.LoadInt64(&c.scheduled))
+}
+
+// Stops a game from being resolved when it ends, used when a game is voided before its end time
+func (c *GameController) RemoveGame(gameId string) {
+	c.removedGames <- gameId
+}
+
+func (c *GameController) consumeRemoved(gameId string) {
+	if c.nextEndingGame != nil && c.nextEndingGame.Id == gameId {
+		// If the timer cannot be stopped the game is already in the finishedGames chan, where it will still
+		// schedule the next game and its resolution will find the game already settled
+		if c.stopTimer() {
+			if c.queue.Len() > 0 {
+				c.nextEndingGame = heap.Pop(&c.queue).(*models.Game)
+				c.setTimer(c.nextEndingGame)
+			} else {
+				c.nextEndingGame = nil
+			}
+		}
+		return
+	}
+	for i, game := range c.queue {
+		if game.Id == gameId {
+			heap.Remove(&c.queue, i)
+			return
+		}
+	}
+}
+
 func (c *GameController) consumeIncoming(game *models.Game) {
 	if c.nextEndingGame == nil {
 		c.nextEndingGame = game
@@ -42,7 +133,7 @@ func (c *GameController) consumeIncoming(game *models.Game) {
 		// Note: timer is never stopped unless there are no more games in the queue, at which
 		// point nextEndingGame will be nil, therefore if false is returned, only possibility is timer just fired, but
 		// the finished game is not yet processed (i.e. in the finishedGames chan)
-		if c.timer.Stop() {
+		if c.stopTimer() {
 			heap.Push(&c.queue, c.nextEndingGame)
 		}
 		c.nextEndingGame = game
@@ -52,39 +143,74 @@ func (c *GameController) consumeIncoming(game *models.Game) {
 	}
 }
 
+// Returns false if the next game has already been sent to finishedGames, either because its timer fired or because
+// it had already ended when it was scheduled
+func (c *GameController) stopTimer() bool {
+	return c.timer != nil && c.timer.Stop()
+}
+
 func (c *GameController) setTimer(game *models.Game) {
-	if !game.EndTime.After(time.Now()) {
-		log.Printf("EXPIRED_GAME: game %s ended at %s (time now is %s)", game.Id,
-			time.Now().Format(TIME_FORMAT), game.EndTime.Format(TIME_FORMAT),
-		)
+	now := c.clock.Now()
+	if !game.EndTime.After(now) {
+		logging.Default().Warn("game already ended when scheduled", "gameId", game.Id, "endTime", game.EndTime,
+			"now", now)
+		c.timer = nil
 		c.finishedGames <- game
 	} else {
-		c.timer = time.AfterFunc(game.EndTime.Sub(time.Now()), func() {
+		c.timer = c.clock.AfterFunc(game.EndTime.Sub(now), func() {
 			c.finishedGames <- game
 		})
 	}
 }
 
+func (c *GameController) consumeFinished(game *models.Game) {
+	// Schedule the next game, if it has not already been updated
+	if game == c.nextEndingGame {
+		if c.queue.Len() > 0 {
+			c.nextEndingGame = heap.Pop(&c.queue).(*models.Game)
+			c.setTimer(c.nextEndingGame)
+		} else {
+			// If there're no more games to process, nextEndingGame should be set to nil
+			c.nextEndingGame = nil
+		}
+	}
+	logging.Default().Info("game ended", "gameId", game.Id)
+	c.resolve(game.Id)
+}
+
 func (c *GameController) gameLoop() {
+	defer close(c.stopped)
+	// Schedule the first of the games added before the loop started
+	if c.nextEndingGame == nil && c.queue.Len() > 0 {
+		c.nextEndingGame = heap.Pop(&c.queue).(*models.Game)
+		c.setTimer(c.nextEndingGame)
+	}
+	c.updateScheduled()
 	for {
 		select {
+		case <-c.stop:
+			c.stopTimer()
+			return
 		case game := <-c.incomingGames:
-			log.Printf("GAME_RECEIVED: %s, %s, %s, ends at %s (created by %s)", game.Id, game.GameMode,
-				game.Stakes, game.EndTime.Format(TIME_FORMAT), game.UserId)
+			logging.Default().Info("game received", "gameId", game.Id, "gameMode", game.GameMode,
+				"stakes", game.Stakes, "endTime", game.EndTime, "userId", game.UserId)
 			c.consumeIncoming(game)
+		case gameId := <-c.removedGames:
+			logging.Default().Info("game removed", "gameId", gameId)
+			c.consumeRemoved(gameId)
 		case game := <-c.finishedGames:
-			// Schedule the next game, if it has not already been updated
-			if game == c.nextEndingGame {
-				if c.queue.Len() > 0 {
-					c.nextEndingGame = heap.Pop(&c.queue).(*models.Game)
-					c.setTimer(c.nextEndingGame)
-				} else {
-					// If there're no more games to process, nextEndingGame should be set to nil
-					c.nextEndingGame = nil
-				}
-			}
-			log.Printf("GAME_ENDED: %s", game.Id)
-			go ResolveGame(game.Id)
+			c.consumeFinished(game)
+		case reply := <-c.pings:
+			close(reply)
 		}
+		c.updateScheduled()
+	}
+}
+
+func (c *GameController) updateScheduled() {
+	scheduled := c.queue.Len()
+	if c.nextEndingGame != nil {
+		scheduled += 1
 	}
+	atomic.StoreInt64(&c.scheduled, int64(scheduled))
 }
@@ -0,0 +1,228 @@
+package logic
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+	"zerosum/models"
+)
+
+func TestRemoveGame(t *testing.T) {
+	now := time.Now()
+	c := NewGameController(newFakeClock(now), func(gameId string) {})
+	first := &models.Game{Id: "first", EndTime: now.Add(time.Hour)}
+	second := &models.Game{Id: "second", EndTime: now.Add(2 * time.Hour)}
+	third := &models.Game{Id: "third", EndTime: now.Add(3 * time.Hour)}
+	c.consumeIncoming(first)
+	c.consumeIncoming(second)
+	c.consumeIncoming(third)
+
+	c.consumeRemoved("second")
+	if c.queue.Len() != 1 || c.queue[0] != third {
+		t.Errorf("Expected only third game left in queue, got %+v", c.queue)
+	}
+
+	// Removing the next game to end schedules the one after it
+	c.consumeRemoved("first")
+	if c.nextEndingGame != third || c.queue.Len() != 0 {
+		t.Errorf("Expected third game to be scheduled next, got %+v", c.nextEndingGame)
+	}
+	c.consumeRemoved("third")
+	if c.nextEndingGame != nil {
+		t.Errorf("Expected no game to be scheduled, got %+v", c.nextEndingGame)
+	}
+
+	// Removing an unknown game leaves the queue untouched
+	heap.Push(&c.queue, first)
+	c.consumeRemoved("unknown")
+	if c.queue.Len() != 1 {
+		t.Errorf("Expected queue to be untouched, got %+v", c.queue)
+	}
+	select {
+	case game := <-c.finishedGames:
+		t.Errorf("Expected no game to finish, got %s", game.Id)
+	default:
+	}
+}
+
+// Controller on a fake clock that reports the games it resolves on the returned channel
+func newTestController(now time.Time) (*GameController, *fakeClock, chan string) {
+	clock := newFakeClock(now)
+	resolved := make(chan string, 10)
+	c := NewGameController(clock, func(gameId string) {
+		resolved <- gameId
+	})
+	return c, clock, resolved
+}
+
+func expectResolved(t *testing.T, resolved chan string, expected ...string) {
+	t.Helper()
+	var got []string
+	for range expected {
+		select {
+		case gameId := <-resolved:
+			got = append(got, gameId)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected games %v to be resolved, got %v", expected, got)
+		}
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected games %v to be resolved in order, got %v", expected, got)
+		}
+	}
+}
+
+func expectNoneResolved(t *testing.T, resolved chan string) {
+	t.Helper()
+	select {
+	case gameId := <-resolved:
+		t.Fatalf("Expected no game to be resolved, got %s", gameId)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestGamesResolveInOrder(t *testing.T) {
+	now := time.Now()
+	c, clock, resolved := newTestController(now)
+	c.consumeIncoming(&models.Game{Id: "third", EndTime: now.Add(3 * time.Hour)})
+	c.consumeIncoming(&models.Game{Id: "first", EndTime: now.Add(time.Hour)})
+	c.consumeIncoming(&models.Game{Id: "second", EndTime: now.Add(2 * time.Hour)})
+	c.Start()
+	defer c.Stop()
+
+	clock.Advance(30 * time.Minute)
+	expectNoneResolved(t, resolved)
+	clock.Advance(30 * time.Minute)
+	expectResolved(t, resolved, "first")
+	// Games that ended while the clock jumped past them are still resolved in the order they ended
+	clock.Advance(4 * time.Hour)
+	expectResolved(t, resolved, "second", "third")
+}
+
+func TestGamesEndingTogether(t *testing.T) {
+	now := time.Now()
+	c, clock, resolved := newTestController(now)
+	for _, id := range []string{"a", "b", "c"} {
+		c.consumeIncoming(&models.Game{Id: id, EndTime: now.Add(time.Hour)})
+	}
+	c.consumeIncoming(&models.Game{Id: "later", EndTime: now.Add(2 * time.Hour)})
+	c.Start()
+	defer c.Stop()
+
+	clock.Advance(time.Hour)
+	got := []string{<-resolved, <-resolved, <-resolved}
+	sort.Strings(got)
+	if got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("Expected every game ending at once to be resolved, got %v", got)
+	}
+	expectNoneResolved(t, resolved)
+	clock.Advance(time.Hour)
+	expectResolved(t, resolved, "later")
+}
+
+func TestGameAddedAsTimerFires(t *testing.T) {
+	now := time.Now()
+	c, clock, resolved := newTestController(now)
+	late := &models.Game{Id: "late", EndTime: now.Add(2 * time.Hour)}
+	early := &models.Game{Id: "early", EndTime: now.Add(time.Hour)}
+	c.consumeIncoming(late)
+
+	// The timer of the late game fires before the game loop gets to an earlier game added just before it
+	clock.Advance(3 * time.Hour)
+	c.consumeIncoming(early)
+	if c.nextEndingGame != early || c.queue.Len() != 0 {
+		t.Fatalf("Expected late game not to be queued again, got %+v in queue", c.queue)
+	}
+	c.consumeFinished(<-c.finishedGames)
+	c.consumeFinished(<-c.finishedGames)
+	if c.nextEndingGame != nil {
+		t.Errorf("Expected no game to be scheduled, got %+v", c.nextEndingGame)
+	}
+	expectResolved(t, resolved, "late", "early")
+	expectNoneResolved(t, resolved)
+}
+
+func TestExpiredGamesRestored(t *testing.T) {
+	now := time.Now()
+	c, clock, resolved := newTestController(now)
+	c.Start()
+	defer c.Stop()
+
+	// Games that ended while the server was down are resolved as soon as they are restored
+	c.AddGame(&models.Game{Id: "expired-late", EndTime: now.Add(-time.Minute)})
+	c.AddGame(&models.Game{Id: "expired-early", EndTime: now.Add(-time.Hour)})
+	c.AddGame(&models.Game{Id: "future", EndTime: now.Add(time.Hour)})
+	got := []string{<-resolved, <-resolved}
+	sort.Strings(got)
+	if got[0] != "expired-early" || got[1] != "expired-late" {
+		t.Errorf("Expected expired games to be resolved, got %v", got)
+	}
+	expectNoneResolved(t, resolved)
+	clock.Advance(time.Hour)
+	expectResolved(t, resolved, "future")
+}
+
+func TestManyGamesRestoredBeforeStart(t *testing.T) {
+	now := time.Now()
+	c, clock, resolved := newTestController(now)
+
+	// More games than incomingGames holds are restored before the loop starts, as after a long downtime
+	count := cap(c.incomingGames) + 50
+	for i := 0; i < count; i++ {
+		c.AddGame(&models.Game{Id: fmt.Sprintf("game-%03d", i), EndTime: now.Add(time.Duration(count-i) * time.Minute)})
+	}
+	c.Start()
+	defer c.Stop()
+	if err := c.Ping(context.Background()); err != nil || c.Scheduled() != count {
+		t.Fatalf("Expected %d games to be scheduled, got %d, %v", count, c.Scheduled(), err)
+	}
+
+	// Games end in order of their end time, not the order they were restored in
+	clock.Advance(time.Minute)
+	expectResolved(t, resolved, fmt.Sprintf("game-%03d", count-1))
+	expectNoneResolved(t, resolved)
+}
+
+func TestStopController(t *testing.T) {
+	now := time.Now()
+	c, clock, resolved := newTestController(now)
+	c.consumeIncoming(&models.Game{Id: "game", EndTime: now.Add(time.Hour)})
+	c.Start()
+	c.Stop()
+
+	// Games left when the controller stops are not resolved until they are restored
+	clock.Advance(time.Hour)
+	expectNoneResolved(t, resolved)
+	select {
+	case game := <-c.finishedGames:
+		t.Errorf("Expected timer to be stopped, got %s", game.Id)
+	default:
+	}
+}
+
+func TestPingController(t *testing.T) {
+	now := time.Now()
+	c, _, _ := newTestController(now)
+	c.consumeIncoming(&models.Game{Id: "first", EndTime: now.Add(time.Hour)})
+	c.consumeIncoming(&models.Game{Id: "second", EndTime: now.Add(2 * time.Hour)})
+	c.Start()
+
+	// Answering the ping also updates the count of scheduled games
+	if err := c.Ping(context.Background()); err != nil {
+		t.Errorf("Expected running game loop to answer, got %v", err)
+	}
+	if c.Scheduled() != 2 {
+		t.Errorf("Expected 2 games scheduled, got %d", c.Scheduled())
+	}
+
+	c.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Ping(ctx); err == nil {
+		t.Errorf("Expected stopped game loop not to answer")
+	}
+}
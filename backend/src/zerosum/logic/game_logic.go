@@ -1,11 +1,12 @@
 package logic
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"zerosum/models"
 	"zerosum/push"
 	"zerosum/repository"
+	"zerosum/tracing"
 )
 
 var EXP_REQUIRED = []int{10, 20, 50, 100, 250, 500, 800, 1250, 2000}
@@ -17,54 +18,43 @@ const (
 	WIN_EXP  = 10
 )
 
-type optionResult struct {
-	Id         string
-	Winner     bool
-	TotalValue int32
-	TotalVotes int32
+type notification struct {
+	userId string
+	body   string
 }
 
 /**
 	DB UPDATES
  */
-func allocateExp(userId string, exp int) (err error) {
-	user, err := repository.QueryUser(models.User{Id: userId})
-	if err == nil {
-		user.Experience += exp
-		err = repository.UpdateUser(user)
-	}
-	return
+// Balance, exp and stats are updated in place by the database, so concurrent updates to a user are never lost
+func allocateExp(tx *repository.Tx, userId string, exp int) (err error) {
+	return tx.AddUserExperience(userId, exp)
 }
 
-func allocateWinOrLoss(userId string, win bool) (err error) {
-	user, err := repository.QueryUser(models.User{Id: userId})
-	if err == nil {
-		user.GamesPlayed += 1
-		if win {
-			user.GamesWon += 1
-		}
-		user.WinRate = float64(user.GamesWon) / float64(user.GamesPlayed)
-		err = repository.UpdateUser(user)
-	}
-	return
+func allocateWinOrLoss(tx *repository.Tx, userId string, win bool) (err error) {
+	return tx.RecordUserGame(userId, win)
 }
 
-func updateVoteResult(userId string, gameId string, win bool, change int32) (err error) {
-	vote, err, _ := repository.QueryVote(models.Vote{GameId: gameId, UserId: userId})
+func updateVoteResult(tx *repository.Tx, userId string, gameId string, win bool, change int32, distance float64) (err error) {
+	vote, err, _ := tx.QueryVote(models.Vote{GameId: gameId, UserId: userId})
 	if err == nil {
 		vote.Resolved = true
 		vote.Win = win
 		vote.Change = change
-		err = repository.UpdateVote(vote)
+		vote.Distance = distance
+		err = tx.UpdateVote(vote)
 	}
 	return
 }
 
-func updateGameResult(gameId string, optionResults []optionResult) (err error) {
-	game, err := repository.QueryGame(models.Game{Id: gameId})
+func updateGameResult(tx *repository.Tx, gameId string, outcome Outcome) (err error) {
+	game, err := tx.QueryGame(models.Game{Id: gameId})
+	if err != nil {
+		return
+	}
 
-	for _, optionRes := range optionResults {
-		option, internal_err := repository.QueryOption(models.Option{Id: optionRes.Id})
+	for _, optionRes := range outcome.Options {
+		option, internal_err := tx.QueryOption(models.Option{Id: optionRes.Id})
 		if internal_err != nil {
 			err = internal_err
 			return
@@ -73,29 +63,31 @@ func updateGameResult(gameId string, optionResults []optionResult) (err error) {
 		option.Winner = optionRes.Winner
 		option.TotalValue = optionRes.TotalValue
 		option.TotalVotes = optionRes.TotalVotes
-		err = repository.UpdateOption(option)
-	}
-
-	if err == nil {
-		game.Resolved = true
-		err = repository.UpdateGame(game)
+		err = tx.UpdateOption(option)
+		if err != nil {
+			return
+		}
 	}
-	return
-}
 
-func AllocateMoney(userId string, money int32) (err error) {
-	user, err := repository.QueryUser(models.User{Id: userId})
-	if err == nil {
-		user.MoneyTotal += money
-		if user.MoneyTotal < 0 {
-			err = errors.New("not enough money")
+	for _, numberRes := range outcome.Numbers {
+		err = tx.CreateNumberResult(numberRes)
+		if err != nil {
 			return
 		}
-		err = repository.UpdateUser(user)
 	}
+
+	game.Resolved = true
+	if outcome.TargetValue != nil {
+		game.TargetValue = *outcome.TargetValue
+	}
+	err = tx.UpdateGame(game)
 	return
 }
 
+func allocateMoney(tx *repository.Tx, userId string, money int32) (err error) {
+	return tx.AdjustUserMoney(userId, money)
+}
+
 func GetLevelInfo(exp int) (level int, progressToNext int, nextMilestone int) {
 	level = 1
 	for _, expRequired := range EXP_REQUIRED {
@@ -124,123 +116,176 @@ func getLevel(exp int) (level int) {
 	return
 }
 
-func AllocateHostExp(userId string) (err error) {
-	return allocateExp(userId, HOST_EXP)
-}
-
-func AllocateVoteExp(userId string) (err error) {
-	return allocateExp(userId, VOTE_EXP)
-}
-
-func AllocateWinExp(userId string) (err error) {
-	return allocateExp(userId, WIN_EXP)
-}
-
-func ResolveGame(gameId string) (err error) {
-
-	// Get list of options for game
-	options, err := repository.QueryGameOptions(models.Game{Id: gameId})
-	optionTotal := make([]int32, len(options))
-	optionCount := make([]int32, len(options))
-	votes := make([][]models.Vote, len(options))
+// Settles a game in a single transaction, paying out winners and recording results. Settling a game that has
+// already been settled is a no-op, and a failed settlement leaves no partial results behind.
+func ResolveGame(ctx context.Context, gameId string) (err error) {
+	span, ctx := tracing.StartSpan(ctx, "ResolveGame")
+	span.SetTag("game.id", gameId)
+	defer func() {
+		tracing.FinishSpan(span, err)
+	}()
 
-	// Calculate total amount for each option in game
-	for i, option := range options {
-		votes[i], err = repository.QueryOptionVotes(option)
-		if err != nil {
+	var notifications []notification
+	err = repository.Transaction(ctx, func(tx *repository.Tx) (err error) {
+		claimed, err := tx.ClaimSettlement(gameId)
+		if err != nil || !claimed {
 			return
 		}
-		for _, vote := range votes[i] {
-			optionTotal[i] += vote.Money
-			optionCount[i] += 1
-		}
+		notifications, err = settleGame(tx, gameId)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	// Only notify players once the results are committed
+	for _, notif := range notifications {
+		push.SendNotif(ctx, notif.body, notif.userId)
 	}
+	return
+}
+
+func settleGame(tx *repository.Tx, gameId string) (notifications []notification, err error) {
 
 	// Get game mode
-	game, err := repository.QueryGame(models.Game{Id: gameId})
+	game, err := tx.LockGame(gameId)
 	if err != nil {
 		return
 	}
-
-	// Check winning option
-	var winningOptions []int
-	var losingOptions []int
-	if game.GameMode == models.MAJORITY {
-		winningOptions, losingOptions = resolveMajority(optionTotal)
-	} else if game.GameMode == models.MINORITY {
-		winningOptions, losingOptions = resolveMinority(optionTotal)
+	// Games resolved before settlements were recorded must not be paid out again, and voided games have no result
+	if game.Resolved || game.Voided {
+		return
+	}
+	mode, err := GetGameMode(game.GameMode)
+	if err != nil {
+		return
 	}
 
-	// Update Game Result
-	optionResults := make([]optionResult, len(options))
-	for _, index := range winningOptions {
-		optionResults[index].Id = options[index].Id
-		optionResults[index].Winner = true
-		optionResults[index].TotalValue = optionTotal[index]
-		optionResults[index].TotalVotes = optionCount[index]
-	}
-	for _, index := range losingOptions {
-		optionResults[index].Id = options[index].Id
-		optionResults[index].Winner = false
-		optionResults[index].TotalValue = optionTotal[index]
-		optionResults[index].TotalVotes = optionCount[index]
+	// Get options and votes of game
+	options, err := tx.QueryGameOptions(models.Game{Id: gameId})
+	if err != nil {
+		return
+	}
+	votes, err := tx.QueryAllGameVotes(models.Game{Id: gameId})
+	if err != nil {
+		return
+	}
+	// Games that did not get enough players or money are refunded instead
+	if reason, void := checkThresholds(game, votes); void {
+		notifications, err = voidGame(tx, game, votes, reason)
+		return
+	}
+	allocations, err := tx.QueryGameAllocations(models.Game{Id: gameId})
+	if err != nil {
+		return
 	}
+	attachAllocations(votes, allocations)
 
-	err = updateGameResult(gameId, optionResults)
+	// Check winners and update Game Result
+	outcome, err := mode.SelectWinners(&game, options, votes)
+	if err != nil {
+		return
+	}
+	err = updateGameResult(tx, gameId, outcome)
 	if err != nil {
 		return
 	}
 
-	// If all losers, should be case where no one voted on a minority game, then set all to winners (just for display)
-	if len(winningOptions) == 0 {
-		if game.GameMode != models.MINORITY {
-			err = errors.New("error in resolving game")
+	// Allocate money and exp, update vote results
+	payouts := mode.ComputePayouts(&game, outcome, votes)
+	for _, payout := range payouts {
+		var awarded []notification
+		awarded, err = settlePayout(tx, game, payout, outcome.Distances[payout.Vote.UserId])
+		if err != nil {
 			return
 		}
-		winningOptions = losingOptions
+		notifications = append(notifications, awarded...)
 	}
+	if remainder := escrowRemainder(votes, payouts); remainder > 0 {
+		err = collectRemainder(tx, gameId, remainder)
+	}
+	return
+}
 
-	// Allocate money and exp, update vote results
-	winPool := int32(0)
-	losePool := int32(0)
-	for _, index := range winningOptions {
-		winPool += optionTotal[index]
+func attachAllocations(votes []models.Vote, allocations []models.Allocation) {
+	byUser := make(map[string][]models.Allocation)
+	for _, allocation := range allocations {
+		byUser[allocation.UserId] = append(byUser[allocation.UserId], allocation)
 	}
-	for _, index := range losingOptions {
-		losePool += optionTotal[index]
+	for i := range votes {
+		votes[i].Allocations = byUser[votes[i].UserId]
 	}
+}
+
+func settlePayout(tx *repository.Tx, game models.Game, payout Payout, distance float64) (notifications []notification, err error) {
+	vote := payout.Vote
 
-	// TODO: Make this one big transaction to prevent corruption, for fun: move rounding error money to dev
-	for _, index := range winningOptions {
-		for _, vote := range votes[index] {
-			moneyGained := vote.Money + int32((float64(vote.Money)/float64(winPool)) * float64(losePool))
-			// Update Vote Result
-			updateVoteResult(vote.UserId, vote.GameId, true, moneyGained)
-			// Allocate money and exp, stats
-			AllocateMoney(vote.UserId, moneyGained)
-			AllocateWinExp(vote.UserId)
-			allocateWinOrLoss(vote.UserId, true)
-			// Verify Achievements
-			verifyAchievements(vote.UserId)
-			push.SendNotif(fmt.Sprintf("You have won %d from %s!!!", moneyGained, game.Topic), vote.UserId)
+	// Update Vote Result, with the net change across all of its allocations
+	err = updateVoteResult(tx, vote.UserId, vote.GameId, payout.Win, payout.Amount-vote.Money, distance)
+	if err != nil {
+		return
+	}
+	for _, allocation := range payout.Allocations {
+		// Votes placed before they could be split have no allocations stored
+		if len(vote.Allocations) == 0 {
+			break
+		}
+		err = tx.UpdateAllocationResult(allocation)
+		if err != nil {
+			return
+		}
+	}
+	// Allocate money and exp, stats
+	if payout.Amount > 0 {
+		err = releaseMoney(tx, payoutKind(payout), vote.UserId, vote.GameId, payout.Amount)
+		if err != nil {
+			return
 		}
 	}
-	for _, index := range losingOptions {
-		for _, vote := range votes[index] {
-			_ = vote.Money
-			// Update Vote Result
-			updateVoteResult(vote.UserId, vote.GameId, false, -vote.Money)
-			// Allocate stats
-			allocateWinOrLoss(vote.UserId, false)
-			// Verify Achievements
-			verifyAchievements(vote.UserId)
-			push.SendNotif(fmt.Sprintf("[Game Ended] %s", game.Topic), vote.UserId)
+	if payout.Win {
+		err = allocateExp(tx, vote.UserId, WIN_EXP)
+		if err != nil {
+			return
 		}
 	}
+	err = allocateWinOrLoss(tx, vote.UserId, payout.Win)
+	if err != nil {
+		return
+	}
+	// Verify Achievements
+	notifications, err = verifyAchievements(tx, vote.UserId)
+	if err != nil {
+		return
+	}
 
+	notifications = append(notifications, notification{vote.UserId, payoutMessage(game, payout)})
 	return
 }
 
+// Stakes given back when the game had no winner are refunds, any other money paid out is winnings, even when a split
+// vote gets back less than it staked
+func payoutKind(payout Payout) models.EntryKind {
+	if payout.Refund {
+		return models.REFUND
+	}
+	return models.PAYOUT
+}
+
+func payoutMessage(game models.Game, payout Payout) string {
+	switch {
+	case payout.Refund:
+		return fmt.Sprintf("[Game Ended] %s had no winner, %d has been refunded", game.Topic, payout.Amount)
+	case !payout.Win && payout.Amount > 0:
+		return fmt.Sprintf("[Game Ended] %s, you won back %d", game.Topic, payout.Amount)
+	case !payout.Win:
+		return fmt.Sprintf("[Game Ended] %s", game.Topic)
+	case game.Stakes == models.NO_STAKES:
+		return fmt.Sprintf("You have won %s!!!", game.Topic)
+	default:
+		return fmt.Sprintf("You have won %d from %s!!!", payout.Amount, game.Topic)
+	}
+}
+
 func resolveMajority(values []int32) (winners []int, losers []int) {
 	max := values[0]
 	for i, value := range values {
@@ -0,0 +1,246 @@
+package logic
+
+import (
+	"errors"
+	"zerosum/apperrors"
+	"zerosum/models"
+)
+
+// A GameMode decides how a game of its kind is validated, who wins it and how the pot is paid out.
+// Modes are registered with RegisterGameMode and looked up by the models.GameMode stored on a game.
+type GameMode interface {
+	// Identifier stored on games and exposed through the GraphQL GameMode enum
+	Name() models.GameMode
+	// Checks the mode specific settings of a game before it is created
+	ValidateGame(game *models.Game) error
+	// Checks that a vote carries the kind of choice the mode expects
+	ValidateVote(game *models.Game, vote *models.Vote) error
+	// Decides the results of every option and which votes won
+	SelectWinners(game *models.Game, options []models.Option, votes []models.Vote) (Outcome, error)
+	// Decides how much money each vote gets back once the winners are known
+	ComputePayouts(game *models.Game, outcome Outcome, votes []models.Vote) []Payout
+}
+
+type OptionResult struct {
+	Id         string
+	Winner     bool
+	TotalValue int32
+	TotalVotes int32
+}
+
+type Outcome struct {
+	Options []OptionResult
+	// Keyed by the id of each winning option, every allocation on a winning option wins
+	WinningOptions map[string]bool
+	// Keyed by the user id of each vote that won as a whole, for games without options
+	Winners map[string]bool
+	// Numeric games only, the value votes were measured against and the distance of each vote from it
+	TargetValue *float64
+	Distances   map[string]float64
+	// Numeric games only, the results of each number picked
+	Numbers []models.NumberResult
+}
+
+type Payout struct {
+	Vote models.Vote
+	// A vote wins if any of its allocations won and it got back at least what it staked
+	Win bool
+	// Money returned to the player, including their own stake
+	Amount int32
+	// Whether Amount is the stake given back because the game ended without a winner, rather than winnings
+	Refund bool
+	// Results of each allocation of the vote, with Win and Change computed
+	Allocations []models.Allocation
+}
+
+var gameModes = make(map[models.GameMode]GameMode)
+var gameModeNames []models.GameMode
+
+func RegisterGameMode(mode GameMode) {
+	if _, exists := gameModes[mode.Name()]; exists {
+		panic("game mode registered twice: " + string(mode.Name()))
+	}
+	gameModes[mode.Name()] = mode
+	gameModeNames = append(gameModeNames, mode.Name())
+}
+
+func GetGameMode(name models.GameMode) (mode GameMode, err error) {
+	mode, ok := gameModes[name]
+	if !ok {
+		err = apperrors.Validation("invalid game mode specified")
+	}
+	return
+}
+
+// Names of all registered game modes, in order of registration
+func GameModeNames() []models.GameMode {
+	return append([]models.GameMode(nil), gameModeNames...)
+}
+
+func init() {
+	RegisterGameMode(optionMode{name: models.MAJORITY, pick: resolveMajority})
+	RegisterGameMode(optionMode{name: models.MINORITY, pick: resolveMinority})
+	RegisterGameMode(beautyContestMode{})
+	RegisterGameMode(lowestUniqueMode{})
+	RegisterGameMode(schellingMode{})
+}
+
+/**
+	OPTION MODES
+ */
+// Game mode where players back one of the options set by the creator, and the options are ranked by their weight
+type optionMode struct {
+	name models.GameMode
+	pick func(weights []int32) (winners []int, losers []int)
+}
+
+func (m optionMode) Name() models.GameMode {
+	return m.name
+}
+
+func (m optionMode) ValidateGame(game *models.Game) (err error) {
+	if len(game.Options) < 2 {
+		err = apperrors.Validation("too few options")
+	}
+	return
+}
+
+func (m optionMode) ValidateVote(game *models.Game, vote *models.Vote) (err error) {
+	if len(vote.Allocations) == 0 {
+		err = apperrors.Validation("no option specified")
+		return
+	}
+	// Votes may be split across several options, as long as there is money to split
+	if len(vote.Allocations) > 1 && game.Stakes == models.NO_STAKES {
+		err = apperrors.Validation("no stakes vote cannot be split")
+		return
+	}
+	picked := make(map[string]bool)
+	for _, allocation := range vote.Allocations {
+		if picked[allocation.OptionId] {
+			err = apperrors.Validation("option picked more than once")
+			return
+		}
+		picked[allocation.OptionId] = true
+		if len(vote.Allocations) > 1 && allocation.Money <= 0 {
+			err = apperrors.Validation("invalid amount specified")
+			return
+		}
+	}
+
+	if vote.Number != nil {
+		err = apperrors.Validation("game does not accept numbers")
+	} else if vote.Answer != "" {
+		err = apperrors.Validation("game does not accept answers")
+	}
+	return
+}
+
+func (m optionMode) SelectWinners(game *models.Game, options []models.Option, votes []models.Vote) (outcome Outcome, err error) {
+	totals, counts := tallyOptions(options, votes)
+
+	// No stakes games have no money on the table so they are decided by vote count
+	weights := totals
+	if game.Stakes == models.NO_STAKES {
+		weights = counts
+	}
+	var winningOptions []int
+	if len(weights) > 0 {
+		winningOptions, _ = m.pick(weights)
+	}
+	if len(options) > 0 && len(winningOptions) == 0 {
+		// Games nobody voted in are voided before winners are selected
+		err = errors.New("error in resolving game")
+		return
+	}
+
+	outcome = optionOutcome(options, votes, totals, counts, winningOptions)
+	return
+}
+
+func (m optionMode) ComputePayouts(game *models.Game, outcome Outcome, votes []models.Vote) []Payout {
+	return splitPot(outcome, votes)
+}
+
+// Builds the outcome of a game decided by its options, where every allocation on a winning option wins
+func optionOutcome(options []models.Option, votes []models.Vote, totals []int32, counts []int32,
+	winningOptions []int) (outcome Outcome) {
+	outcome.Options = make([]OptionResult, len(options))
+	outcome.WinningOptions = make(map[string]bool)
+	for i, option := range options {
+		outcome.Options[i] = OptionResult{Id: option.Id, TotalValue: totals[i], TotalVotes: counts[i]}
+	}
+	for _, index := range winningOptions {
+		outcome.Options[index].Winner = true
+		outcome.WinningOptions[options[index].Id] = true
+	}
+	return
+}
+
+func (outcome Outcome) allocationWins(vote models.Vote, allocation models.Allocation) bool {
+	return outcome.Winners[vote.UserId] || (allocation.OptionId != "" && outcome.WinningOptions[allocation.OptionId])
+}
+
+// Allocations of a vote, votes placed before they could be split only carry their single option
+func voteAllocations(vote models.Vote) []models.Allocation {
+	if len(vote.Allocations) > 0 {
+		return vote.Allocations
+	}
+	return []models.Allocation{{GameId: vote.GameId, UserId: vote.UserId, OptionId: vote.OptionId, Money: vote.Money}}
+}
+
+// Sums up the money and number of players placed on each option
+func tallyOptions(options []models.Option, votes []models.Vote) (totals []int32, counts []int32) {
+	totals = make([]int32, len(options))
+	counts = make([]int32, len(options))
+	index := make(map[string]int)
+	for i, option := range options {
+		index[option.Id] = i
+	}
+	for _, vote := range votes {
+		for _, allocation := range voteAllocations(vote) {
+			if i, ok := index[allocation.OptionId]; ok {
+				totals[i] += allocation.Money
+				counts[i] += 1
+			}
+		}
+	}
+	return
+}
+
+// Pays every winning allocation its stake back plus a share of the losing stakes in proportion to its own stake
+func splitPot(outcome Outcome, votes []models.Vote) (payouts []Payout) {
+	winPool := int32(0)
+	losePool := int32(0)
+	for _, vote := range votes {
+		for _, allocation := range voteAllocations(vote) {
+			if outcome.allocationWins(vote, allocation) {
+				winPool += allocation.Money
+			} else {
+				losePool += allocation.Money
+			}
+		}
+	}
+
+	for _, vote := range votes {
+		payout := Payout{Vote: vote}
+		wonAny := false
+		for _, allocation := range voteAllocations(vote) {
+			allocation.Change = -allocation.Money
+			if outcome.allocationWins(vote, allocation) {
+				wonAny = true
+				allocation.Win = true
+				allocation.Change = 0
+				if winPool > 0 {
+					moneyGained := allocation.Money + int32((float64(allocation.Money)/float64(winPool))*float64(losePool))
+					allocation.Change = moneyGained - allocation.Money
+					payout.Amount += moneyGained
+				}
+			}
+			payout.Allocations = append(payout.Allocations, allocation)
+		}
+		payout.Win = wonAny && payout.Amount >= vote.Money
+		payouts = append(payouts, payout)
+	}
+	return
+}
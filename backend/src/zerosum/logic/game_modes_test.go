@@ -0,0 +1,209 @@
+package logic
+
+import (
+	"testing"
+	"zerosum/models"
+)
+
+var testOptions = []models.Option{{Id: "a"}, {Id: "b"}, {Id: "c"}}
+
+func payoutsByUser(payouts []Payout) map[string]Payout {
+	byUser := make(map[string]Payout)
+	for _, payout := range payouts {
+		byUser[payout.Vote.UserId] = payout
+	}
+	return byUser
+}
+
+func TestRegisteredGameModes(t *testing.T) {
+	for _, name := range []models.GameMode{models.MAJORITY, models.MINORITY} {
+		mode, err := GetGameMode(name)
+		if err != nil {
+			t.Fatalf("Game mode %s not registered: %v", name, err)
+		}
+		if mode.Name() != name {
+			t.Errorf("Expected game mode %s, got %s", name, mode.Name())
+		}
+	}
+	if _, err := GetGameMode("UNKNOWN"); err == nil {
+		t.Errorf("Expected error for unknown game mode")
+	}
+}
+
+func TestOptionModeValidateGame(t *testing.T) {
+	mode, _ := GetGameMode(models.MAJORITY)
+	if err := mode.ValidateGame(&models.Game{Options: []models.Option{{Body: "Yes"}}}); err == nil {
+		t.Errorf("Expected error for game with a single option")
+	}
+	if err := mode.ValidateGame(&models.Game{Options: []models.Option{{Body: "Yes"}, {Body: "No"}}}); err != nil {
+		t.Errorf("Unexpected error for game with two options: %v", err)
+	}
+}
+
+func TestMajorityMode(t *testing.T) {
+	game := &models.Game{GameMode: models.MAJORITY, Stakes: models.NO_LIMIT}
+	votes := []models.Vote{
+		{UserId: "u1", OptionId: "a", Money: 100},
+		{UserId: "u2", OptionId: "a", Money: 300},
+		{UserId: "u3", OptionId: "b", Money: 200},
+	}
+	mode, _ := GetGameMode(models.MAJORITY)
+	outcome, err := mode.SelectWinners(game, testOptions, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+	if !outcome.Options[0].Winner || outcome.Options[1].Winner || outcome.Options[2].Winner {
+		t.Errorf("Expected only option a to win, got %+v", outcome.Options)
+	}
+	if outcome.Options[0].TotalValue != 400 || outcome.Options[0].TotalVotes != 2 {
+		t.Errorf("Wrong tally for option a: %+v", outcome.Options[0])
+	}
+
+	payouts := payoutsByUser(mode.ComputePayouts(game, outcome, votes))
+	if p := payouts["u1"]; !p.Win || p.Amount != 150 {
+		t.Errorf("Expected u1 to win 150, got %+v", p)
+	}
+	if p := payouts["u2"]; !p.Win || p.Amount != 450 {
+		t.Errorf("Expected u2 to win 450, got %+v", p)
+	}
+	if p := payouts["u3"]; p.Win || p.Amount != 0 {
+		t.Errorf("Expected u3 to lose, got %+v", p)
+	}
+}
+
+func TestMinorityMode(t *testing.T) {
+	game := &models.Game{GameMode: models.MINORITY, Stakes: models.NO_LIMIT}
+	votes := []models.Vote{
+		{UserId: "u1", OptionId: "a", Money: 100},
+		{UserId: "u2", OptionId: "a", Money: 300},
+		{UserId: "u3", OptionId: "b", Money: 200},
+	}
+	mode, _ := GetGameMode(models.MINORITY)
+	outcome, err := mode.SelectWinners(game, testOptions, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+	// Option c has no votes so it cannot win
+	if outcome.Options[0].Winner || !outcome.Options[1].Winner || outcome.Options[2].Winner {
+		t.Errorf("Expected only option b to win, got %+v", outcome.Options)
+	}
+
+	payouts := payoutsByUser(mode.ComputePayouts(game, outcome, votes))
+	if p := payouts["u3"]; !p.Win || p.Amount != 600 {
+		t.Errorf("Expected u3 to win 600, got %+v", p)
+	}
+	if p := payouts["u1"]; p.Win {
+		t.Errorf("Expected u1 to lose, got %+v", p)
+	}
+}
+
+func TestMinorityModeWithoutVotes(t *testing.T) {
+	// Games without votes are voided before they get here, so selecting winners for one is an error
+	game := &models.Game{GameMode: models.MINORITY, Stakes: models.NO_LIMIT}
+	mode, _ := GetGameMode(models.MINORITY)
+	if _, err := mode.SelectWinners(game, testOptions, nil); err == nil {
+		t.Errorf("Expected error for minority game without votes")
+	}
+}
+
+func TestNoStakesDecidedByCount(t *testing.T) {
+	game := &models.Game{GameMode: models.MAJORITY, Stakes: models.NO_STAKES}
+	votes := []models.Vote{
+		{UserId: "u1", OptionId: "a"},
+		{UserId: "u2", OptionId: "b"},
+		{UserId: "u3", OptionId: "b"},
+	}
+	mode, _ := GetGameMode(models.MAJORITY)
+	outcome, err := mode.SelectWinners(game, testOptions, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+	payouts := payoutsByUser(mode.ComputePayouts(game, outcome, votes))
+	if !payouts["u2"].Win || !payouts["u3"].Win || payouts["u1"].Win {
+		t.Errorf("Expected u2 and u3 to win, got %+v", payouts)
+	}
+	for _, payout := range payouts {
+		if payout.Amount != 0 {
+			t.Errorf("Expected no money paid out in no stakes game, got %+v", payout)
+		}
+	}
+}
+
+func TestSplitVote(t *testing.T) {
+	game := &models.Game{GameMode: models.MAJORITY, Stakes: models.NO_LIMIT}
+	votes := []models.Vote{
+		{UserId: "u1", Money: 200, Allocations: []models.Allocation{
+			{OptionId: "a", Money: 100},
+			{OptionId: "b", Money: 100},
+		}},
+		{UserId: "u2", OptionId: "b", Money: 100},
+		{UserId: "u3", OptionId: "c", Money: 100},
+	}
+	mode, _ := GetGameMode(models.MAJORITY)
+	if err := mode.ValidateVote(game, &votes[0]); err != nil {
+		t.Fatalf("Unexpected error for split vote: %v", err)
+	}
+	outcome, err := mode.SelectWinners(game, testOptions, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+	if outcome.Options[1].TotalValue != 200 || outcome.Options[1].TotalVotes != 2 || !outcome.Options[1].Winner {
+		t.Errorf("Expected option b to win with 200 from 2 players, got %+v", outcome.Options[1])
+	}
+
+	// Losing pool of 200 is split between the two 100 allocations on b
+	payouts := payoutsByUser(mode.ComputePayouts(game, outcome, votes))
+	if p := payouts["u1"]; !p.Win || p.Amount != 200 || len(p.Allocations) != 2 {
+		t.Errorf("Expected u1 to break even, got %+v", p)
+	}
+	if a := payouts["u1"].Allocations[0]; a.Win || a.Change != -100 {
+		t.Errorf("Expected u1 to lose allocation on a, got %+v", a)
+	}
+	if a := payouts["u1"].Allocations[1]; !a.Win || a.Change != 100 {
+		t.Errorf("Expected u1 to win 100 on b, got %+v", a)
+	}
+	if p := payouts["u2"]; !p.Win || p.Amount != 200 {
+		t.Errorf("Expected u2 to win 200, got %+v", p)
+	}
+}
+
+func TestSplitVotePartlyWon(t *testing.T) {
+	game := &models.Game{Topic: "Cats or dogs?", GameMode: models.MAJORITY, Stakes: models.NO_LIMIT}
+	votes := []models.Vote{
+		{UserId: "u1", Money: 200, Allocations: []models.Allocation{
+			{OptionId: "a", Money: 50},
+			{OptionId: "b", Money: 150},
+		}},
+		{UserId: "u2", OptionId: "a", Money: 300},
+	}
+	mode, _ := GetGameMode(models.MAJORITY)
+	outcome, err := mode.SelectWinners(game, testOptions, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+
+	// u1 gets 50 back on a plus 50/350 of the 150 lost on b, less than it staked but still winnings
+	payout := payoutsByUser(mode.ComputePayouts(game, outcome, votes))["u1"]
+	if payout.Win || payout.Refund || payout.Amount != 71 {
+		t.Fatalf("Expected u1 to get back 71 without a refund, got %+v", payout)
+	}
+	if kind := payoutKind(payout); kind != models.PAYOUT {
+		t.Errorf("Expected money won back to be booked as %s, got %s", models.PAYOUT, kind)
+	}
+	if body := payoutMessage(*game, payout); body != "[Game Ended] Cats or dogs?, you won back 71" {
+		t.Errorf("Expected notification of the money won back, got %q", body)
+	}
+}
+
+func TestSplitVoteValidation(t *testing.T) {
+	mode, _ := GetGameMode(models.MAJORITY)
+	game := &models.Game{Stakes: models.NO_LIMIT}
+	duplicate := &models.Vote{Allocations: []models.Allocation{{OptionId: "a", Money: 100}, {OptionId: "a", Money: 100}}}
+	if err := mode.ValidateVote(game, duplicate); err == nil {
+		t.Errorf("Expected error for option picked twice")
+	}
+	noStakes := &models.Vote{Allocations: []models.Allocation{{OptionId: "a"}, {OptionId: "b"}}}
+	if err := mode.ValidateVote(&models.Game{Stakes: models.NO_STAKES}, noStakes); err == nil {
+		t.Errorf("Expected error for split vote in no stakes game")
+	}
+}
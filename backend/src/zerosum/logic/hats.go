@@ -1,9 +1,8 @@
 package logic
 
 import (
-	"log"
+	"zerosum/logging"
 	"zerosum/models"
-	"zerosum/push"
 	"zerosum/repository"
 )
 
@@ -195,71 +194,90 @@ func FormHatRelations(userId string) (err error) {
 	return
 }
 
-func validateOrAward(hatId string, userId string) {
-	hatOwnership, err := repository.QueryHatOwnership(models.HatOwnership{UserId: userId, HatId: hatId})
+func validateOrAward(tx *repository.Tx, hatId string, userId string) (awarded bool, err error) {
+	hatOwnership, err := tx.QueryHatOwnership(models.HatOwnership{UserId: userId, HatId: hatId})
 	if err != nil {
-		log.Print("Critical error: hat ownership inconsistent")
+		logging.FromContext(tx.Context()).Error("hat ownership inconsistent", "userId", userId, "hatId", hatId,
+			"error", err)
+		return
 	}
 	if (!hatOwnership.Owned) {
-		push.SendNotif("HATchievement unlocked! Log in to view!!", userId)
+		awarded = true
 	}
 	hatOwnership.Owned = true
-	repository.UpdateHatOwnership(hatOwnership)
+	err = tx.UpdateHatOwnership(hatOwnership)
 	return
 }
 
-func verifyAchievements(userId string) {
-	user, err := repository.QueryUser(models.User{Id: userId})
+// Awards any achievement hats the user has earned, returning a notification for each newly unlocked hat
+func verifyAchievements(tx *repository.Tx, userId string) (notifications []notification, err error) {
+	user, err := tx.QueryUser(models.User{Id: userId})
 	if err != nil {
-		log.Print("failed to get user while allocating achievements")
+		logging.FromContext(tx.Context()).Error("failed to get user while allocating achievements", "userId", userId,
+			"error", err)
+		return
 	}
+
+	var earned []string
 	// WIN or LOSS milestones
 	if user.GamesPlayed - user.GamesWon >= 10 {
-		validateOrAward(LOSE_TEN_GAMES, user.Id)
+		earned = append(earned, LOSE_TEN_GAMES)
 	}
 	if user.GamesWon >= 10 {
-		validateOrAward(WIN_TEN_GAMES, user.Id)
+		earned = append(earned, WIN_TEN_GAMES)
 	}
 
 	// PLAY milestones
 	switch {
 	case user.GamesPlayed >= 50:
-		validateOrAward(PLAYED_FIFTY_GAMES, user.Id)
+		earned = append(earned, PLAYED_FIFTY_GAMES)
 		fallthrough
 	case user.GamesPlayed >= 25:
-		validateOrAward(PLAYED_TWENTYFIVE_GAMES, user.Id)
+		earned = append(earned, PLAYED_TWENTYFIVE_GAMES)
 		fallthrough
 	case user.GamesPlayed >= 5:
-		validateOrAward(PLAYED_FIVE_GAMES, user.Id)
+		earned = append(earned, PLAYED_FIVE_GAMES)
 	}
 
 	// LEVEL milestones
 	switch {
 	case getLevel(user.Experience) >= 10:
-		validateOrAward(LEVEL_TEN, user.Id)
+		earned = append(earned, LEVEL_TEN)
 		fallthrough
 	case getLevel(user.Experience) >= 9:
-		validateOrAward(LEVEL_NINE, user.Id)
+		earned = append(earned, LEVEL_NINE)
 		fallthrough
 	case getLevel(user.Experience) >= 8:
-		validateOrAward(LEVEL_EIGHT, user.Id)
+		earned = append(earned, LEVEL_EIGHT)
 		fallthrough
 	case getLevel(user.Experience) >= 7:
-		validateOrAward(LEVEL_SEVEN, user.Id)
+		earned = append(earned, LEVEL_SEVEN)
 		fallthrough
 	case getLevel(user.Experience) >= 6:
-		validateOrAward(LEVEL_SIX, user.Id)
+		earned = append(earned, LEVEL_SIX)
 		fallthrough
 	case getLevel(user.Experience) >= 5:
-		validateOrAward(LEVEL_FIVE, user.Id)
+		earned = append(earned, LEVEL_FIVE)
 		fallthrough
 	case getLevel(user.Experience) >= 4:
-		validateOrAward(LEVEL_FOUR, user.Id)
+		earned = append(earned, LEVEL_FOUR)
 		fallthrough
 	case getLevel(user.Experience) >= 3:
-		validateOrAward(LEVEL_THREE, user.Id)
+		earned = append(earned, LEVEL_THREE)
 		fallthrough
 	case getLevel(user.Experience) >= 2:
-		validateOrAward(LEVEL_TWO, user.Id)
+		earned = append(earned, LEVEL_TWO)
+	}
+
+	for _, hatId := range earned {
+		awarded, internalErr := validateOrAward(tx, hatId, user.Id)
+		if internalErr != nil {
+			err = internalErr
+			return
+		}
+		if awarded {
+			notifications = append(notifications, notification{user.Id, "HATchievement unlocked! Log in to view!!"})
+		}
 	}
-}
\ No newline at end of file
+	return
+}
@@ -0,0 +1,79 @@
+package logic
+
+import (
+	"context"
+	"time"
+	"zerosum/apperrors"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+// How long a mutation's idempotency key is remembered for
+const IDEMPOTENCY_WINDOW = 24 * time.Hour
+
+const (
+	ADD_GAME_OPERATION = "addGame"
+	ADD_VOTE_OPERATION = "addVote"
+	BUY_HAT_OPERATION  = "buyHat"
+)
+
+var ErrIdempotencyKeyReused = apperrors.Validation("idempotency key used for a different request").
+	With("field", "idempotencyKey")
+
+// Runs fn in a transaction at most once per idempotency key. If the key was already used by an earlier run, fn is
+// skipped and the id of what that run created is returned, so the caller can replay its response. Runs without a
+// key always run fn.
+func runIdempotent(ctx context.Context, userId string, key string, operation string,
+	fn func(tx *repository.Tx) (resultId string, err error)) (resultId string, replayed bool, err error) {
+	err = repository.Transaction(ctx, func(tx *repository.Tx) (err error) {
+		if key != "" {
+			claimed, existing, err := tx.ClaimIdempotencyKey(userId, key, operation, IDEMPOTENCY_WINDOW)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				if existing.Operation != operation {
+					return ErrIdempotencyKeyReused
+				}
+				resultId = existing.ResultId
+				replayed = true
+				return nil
+			}
+		}
+
+		resultId, err = fn(tx)
+		if err != nil || key == "" {
+			return
+		}
+		return tx.SetIdempotencyResult(userId, key, resultId)
+	})
+	return
+}
+
+// Creates a game, gives its creator the exp for hosting it and queues it to be resolved when it ends. Retrying with
+// the same idempotency key returns the game created the first time.
+func CreateGame(ctx context.Context, newGame models.Game, idempotencyKey string) (game models.Game, err error) {
+	gameId, replayed, err := runIdempotent(ctx, newGame.UserId, idempotencyKey, ADD_GAME_OPERATION,
+		func(tx *repository.Tx) (resultId string, err error) {
+			err = allocateExp(tx, newGame.UserId, HOST_EXP)
+			if err != nil {
+				return
+			}
+			game, err = tx.CreateGame(newGame)
+			if err != nil {
+				return
+			}
+			resultId = game.Id
+			err = tx.EnqueueResolutionJob(game.Id, game.EndTime)
+			return
+		})
+	if err != nil {
+		return
+	}
+	if replayed {
+		game, err = repository.QueryGame(ctx, models.Game{Id: gameId})
+		return
+	}
+	Controller.AddGame(&game)
+	return
+}
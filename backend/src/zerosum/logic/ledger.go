@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"zerosum/apperrors"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+/**
+	LEDGER
+ */
+// Moves money between two accounts and records it in the ledger, updating the balance of the player on either side
+func transfer(tx *repository.Tx, entry models.LedgerEntry) (err error) {
+	if entry.Amount < 0 {
+		err = errors.New("invalid transfer amount")
+		return
+	}
+	if entry.Amount == 0 {
+		return
+	}
+	switch models.UserAccount(entry.UserId) {
+	case entry.Debit:
+		err = allocateMoney(tx, entry.UserId, -entry.Amount)
+	case entry.Credit:
+		err = allocateMoney(tx, entry.UserId, entry.Amount)
+	}
+	if err != nil {
+		return
+	}
+	err = tx.CreateLedgerEntry(entry)
+	return
+}
+
+// Takes a player's stake into the game's escrow
+func stakeMoney(tx *repository.Tx, userId string, gameId string, amount int32) error {
+	return transfer(tx, models.LedgerEntry{
+		Kind:   models.STAKE,
+		Debit:  models.UserAccount(userId),
+		Credit: models.GameAccount(gameId),
+		Amount: amount,
+		UserId: userId,
+		GameId: gameId,
+	})
+}
+
+// Pays a player out of the game's escrow, either their winnings or a refund of their stake
+func releaseMoney(tx *repository.Tx, kind models.EntryKind, userId string, gameId string, amount int32) error {
+	return transfer(tx, models.LedgerEntry{
+		Kind:   kind,
+		Debit:  models.GameAccount(gameId),
+		Credit: models.UserAccount(userId),
+		Amount: amount,
+		UserId: userId,
+		GameId: gameId,
+	})
+}
+
+// Moves whatever is left in a settled game's escrow to the house, so that no money disappears when payouts are
+// rounded down
+func collectRemainder(tx *repository.Tx, gameId string, remainder int32) error {
+	return transfer(tx, models.LedgerEntry{
+		Kind:   models.ROUNDING,
+		Debit:  models.GameAccount(gameId),
+		Credit: models.HOUSE_ACCOUNT,
+		Amount: remainder,
+		GameId: gameId,
+	})
+}
+
+// Money staked on a game that its payouts did not give back
+func escrowRemainder(votes []models.Vote, payouts []Payout) (remainder int32) {
+	for _, vote := range votes {
+		remainder += vote.Money
+	}
+	for _, payout := range payouts {
+		remainder -= payout.Amount
+	}
+	return
+}
+
+// Pays for a hat from the store and hands it over to the player. Retrying with the same idempotency key does
+// nothing, as long as it is for the same hat.
+func BuyHat(ctx context.Context, userId string, hat models.Hat, idempotencyKey string) (err error) {
+	hatId, replayed, err := runIdempotent(ctx, userId, idempotencyKey, BUY_HAT_OPERATION,
+		func(tx *repository.Tx) (string, error) {
+			return hat.Id, buyHat(tx, userId, hat)
+		})
+	if err == nil && replayed && hatId != hat.Id {
+		err = ErrIdempotencyKeyReused
+	}
+	return
+}
+
+func buyHat(tx *repository.Tx, userId string, hat models.Hat) (err error) {
+	ownership, err := tx.LockHatOwnership(models.HatOwnership{HatId: hat.Id, UserId: userId})
+	if err != nil {
+		return
+	}
+	if ownership.Owned {
+		err = apperrors.Validation("hat already owned")
+		return
+	}
+	err = transfer(tx, models.LedgerEntry{
+		Kind:   models.PURCHASE,
+		Debit:  models.UserAccount(userId),
+		Credit: models.HOUSE_ACCOUNT,
+		Amount: hat.Price,
+		UserId: userId,
+		HatId:  hat.Id,
+	})
+	if err != nil {
+		return
+	}
+	ownership.Owned = true
+	err = tx.UpdateHatOwnership(ownership)
+	return
+}
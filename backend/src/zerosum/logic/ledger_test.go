@@ -0,0 +1,25 @@
+package logic
+
+import (
+	"testing"
+	"zerosum/models"
+)
+
+func TestEscrowRemainder(t *testing.T) {
+	game := &models.Game{GameMode: models.MAJORITY, Stakes: models.NO_LIMIT}
+	votes := []models.Vote{
+		{UserId: "u1", OptionId: "a", Money: 100},
+		{UserId: "u2", OptionId: "a", Money: 200},
+		{UserId: "u3", OptionId: "b", Money: 100},
+	}
+	mode, _ := GetGameMode(models.MAJORITY)
+	outcome, err := mode.SelectWinners(game, testOptions, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+	// u1 gets 100 + 33 and u2 gets 200 + 66, leaving 1 of the pot unpaid
+	payouts := mode.ComputePayouts(game, outcome, votes)
+	if remainder := escrowRemainder(votes, payouts); remainder != 1 {
+		t.Errorf("Expected remainder of 1, got %d", remainder)
+	}
+}
@@ -0,0 +1,84 @@
+package logic
+
+import (
+	"math"
+	"sort"
+	"zerosum/apperrors"
+	"zerosum/models"
+)
+
+// Lowest unique bid, players pick a positive whole number and the smallest number picked by exactly one player wins
+type lowestUniqueMode struct{}
+
+func (m lowestUniqueMode) Name() models.GameMode {
+	return models.LOWEST_UNIQUE
+}
+
+func (m lowestUniqueMode) ValidateGame(game *models.Game) (err error) {
+	if len(game.Options) > 0 {
+		err = apperrors.Validation("numeric game does not take options")
+	}
+	return
+}
+
+func (m lowestUniqueMode) ValidateVote(game *models.Game, vote *models.Vote) (err error) {
+	if vote.Number == nil {
+		err = apperrors.Validation("no number specified")
+	} else if vote.OptionId != "" || len(vote.Allocations) > 0 {
+		err = apperrors.Validation("game does not accept options")
+	} else if *vote.Number < 1 || *vote.Number > math.MaxInt32 || *vote.Number != math.Trunc(*vote.Number) {
+		err = apperrors.Validation("number must be a positive whole number")
+	}
+	return
+}
+
+func (m lowestUniqueMode) SelectWinners(game *models.Game, options []models.Option, votes []models.Vote) (outcome Outcome, err error) {
+	outcome.Winners = make(map[string]bool)
+
+	// Tally how many players collided on each number
+	results := make(map[int32]*models.NumberResult)
+	for _, vote := range votes {
+		if vote.Number == nil {
+			continue
+		}
+		number := int32(*vote.Number)
+		result, ok := results[number]
+		if !ok {
+			result = &models.NumberResult{GameId: game.Id, Number: number}
+			results[number] = result
+		}
+		result.TotalValue += vote.Money
+		result.TotalVotes += 1
+	}
+	for _, result := range results {
+		outcome.Numbers = append(outcome.Numbers, *result)
+	}
+	sort.Slice(outcome.Numbers, func(i, j int) bool {
+		return outcome.Numbers[i].Number < outcome.Numbers[j].Number
+	})
+
+	// If every number collided there is no winner and everyone is refunded
+	for i := range outcome.Numbers {
+		if outcome.Numbers[i].TotalVotes == 1 {
+			outcome.Numbers[i].Winner = true
+			winningNumber := float64(outcome.Numbers[i].Number)
+			for _, vote := range votes {
+				if vote.Number != nil && *vote.Number == winningNumber {
+					outcome.Winners[vote.UserId] = true
+				}
+			}
+			break
+		}
+	}
+	return
+}
+
+func (m lowestUniqueMode) ComputePayouts(game *models.Game, outcome Outcome, votes []models.Vote) (payouts []Payout) {
+	if len(outcome.Winners) > 0 {
+		return splitPot(outcome, votes)
+	}
+	for _, vote := range votes {
+		payouts = append(payouts, Payout{Vote: vote, Amount: vote.Money, Refund: true})
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package logic
+
+import (
+	"testing"
+	"zerosum/models"
+)
+
+func TestLowestUniqueValidateVote(t *testing.T) {
+	mode, _ := GetGameMode(models.LOWEST_UNIQUE)
+	game := &models.Game{}
+	if err := mode.ValidateVote(game, &models.Vote{Number: number(3)}); err != nil {
+		t.Errorf("Unexpected error for positive whole number: %v", err)
+	}
+	for _, n := range []float64{0, -1, 2.5} {
+		if err := mode.ValidateVote(game, &models.Vote{Number: number(n)}); err == nil {
+			t.Errorf("Expected error for number %v", n)
+		}
+	}
+}
+
+func TestLowestUniqueMode(t *testing.T) {
+	game := &models.Game{Id: "g", Stakes: models.NO_LIMIT}
+	votes := []models.Vote{
+		{UserId: "u1", Number: number(1), Money: 100},
+		{UserId: "u2", Number: number(1), Money: 100},
+		{UserId: "u3", Number: number(2), Money: 100},
+		{UserId: "u4", Number: number(5), Money: 100},
+	}
+	mode, _ := GetGameMode(models.LOWEST_UNIQUE)
+	outcome, err := mode.SelectWinners(game, nil, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+	if len(outcome.Numbers) != 3 {
+		t.Fatalf("Expected 3 numbers, got %+v", outcome.Numbers)
+	}
+	if n := outcome.Numbers[0]; n.Number != 1 || n.TotalVotes != 2 || n.Winner {
+		t.Errorf("Expected collision on 1, got %+v", n)
+	}
+	if n := outcome.Numbers[1]; n.Number != 2 || n.TotalVotes != 1 || !n.Winner {
+		t.Errorf("Expected 2 to win, got %+v", n)
+	}
+	if n := outcome.Numbers[2]; n.Winner {
+		t.Errorf("Expected 5 to lose, got %+v", n)
+	}
+
+	payouts := payoutsByUser(mode.ComputePayouts(game, outcome, votes))
+	if p := payouts["u3"]; !p.Win || p.Amount != 400 {
+		t.Errorf("Expected u3 to win the pot of 400, got %+v", p)
+	}
+	if p := payouts["u1"]; p.Win || p.Amount != 0 {
+		t.Errorf("Expected u1 to lose, got %+v", p)
+	}
+}
+
+func TestLowestUniqueAllCollided(t *testing.T) {
+	game := &models.Game{Id: "g", Stakes: models.NO_LIMIT}
+	votes := []models.Vote{
+		{UserId: "u1", Number: number(1), Money: 100},
+		{UserId: "u2", Number: number(1), Money: 200},
+	}
+	mode, _ := GetGameMode(models.LOWEST_UNIQUE)
+	outcome, _ := mode.SelectWinners(game, nil, votes)
+	if len(outcome.Winners) != 0 {
+		t.Errorf("Expected no winners, got %+v", outcome.Winners)
+	}
+	for _, payout := range mode.ComputePayouts(game, outcome, votes) {
+		if payout.Win || !payout.Refund || payout.Amount != payout.Vote.Money || payoutKind(payout) != models.REFUND {
+			t.Errorf("Expected stake to be refunded, got %+v", payout)
+		}
+	}
+}
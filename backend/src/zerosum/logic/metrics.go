@@ -0,0 +1,87 @@
+package logic
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"zerosum/logging"
+	"zerosum/metrics"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+// Summing the ledger of the house account reads every entry paid out of it, so it is done on a timer rather than on
+// every scrape
+const MONEY_REFRESH_INTERVAL = time.Minute
+
+var (
+	resolutionsTotal = metrics.NewCounter("zerosum_resolutions_total",
+		"Attempts at resolving a game, by outcome (resolved, failed or dead).", "outcome")
+	resolutionDuration = metrics.NewHistogram("zerosum_resolution_duration_seconds",
+		"Time taken by attempts at resolving a game, by outcome.", metrics.DEFAULT_BUCKETS, "outcome")
+	_ = metrics.NewGaugeFunc("zerosum_scheduled_games", "Games waiting to end on this server.",
+		func() (float64, error) {
+			return float64(Controller.Scheduled()), nil
+		})
+	// Every coin held by players or staked in games was paid out of the house account, so this is all money in play
+	_ = metrics.NewGaugeFunc("zerosum_money_in_circulation", "Money held by players or staked in games.",
+		moneyInCirculation.get)
+)
+
+// Last money in circulation read from the ledger, unavailable until the first refresh
+var moneyInCirculation = &cachedGauge{err: errNotRefreshed}
+
+var errNotRefreshed = errors.New("money in circulation not read yet")
+
+type cachedGauge struct {
+	mu    sync.Mutex
+	value float64
+	err   error
+}
+
+func (g *cachedGauge) get() (float64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value, g.err
+}
+
+func (g *cachedGauge) set(value float64, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value, g.err = value, err
+}
+
+func refreshMoneyInCirculation() {
+	balance, err := repository.QueryAccountBalance(models.HOUSE_ACCOUNT)
+	if err != nil {
+		logging.Default().Error("failed to read money in circulation", "error", err)
+	}
+	moneyInCirculation.set(float64(-balance), err)
+}
+
+// Keeps gauges read from the database up to date, started once the database is set up
+func StartMetricsRefresh() {
+	refreshMoneyInCirculation()
+	runInBackground(func() {
+		ticker := time.NewTicker(MONEY_REFRESH_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopWorker:
+				return
+			case <-ticker.C:
+				refreshMoneyInCirculation()
+			}
+		}
+	})
+}
+
+func resolutionOutcome(job models.ResolutionJob) string {
+	switch job.Status {
+	case models.DONE:
+		return "resolved"
+	case models.DEAD:
+		return "dead"
+	}
+	return "failed"
+}
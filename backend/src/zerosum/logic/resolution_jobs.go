@@ -0,0 +1,189 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"github.com/segmentio/ksuid"
+	"os"
+	"sync"
+	"time"
+	"zerosum/apperrors"
+	"zerosum/logging"
+	"zerosum/models"
+	"zerosum/repository"
+	"zerosum/tracing"
+)
+
+const (
+	MAX_RESOLUTION_ATTEMPTS = 8
+	RESOLUTION_BACKOFF      = 10 * time.Second
+	MAX_RESOLUTION_BACKOFF  = 30 * time.Minute
+	RESOLUTION_POLL         = 5 * time.Second
+	RESOLUTION_BATCH        = 20
+	// Longest a server may take to run a job before another server takes it over, far longer than a settlement takes
+	RESOLUTION_LEASE = time.Minute
+)
+
+// Resolutions running in the background, along with the notifications they send and the metrics refresh, waited on
+// when shutting down
+var background sync.WaitGroup
+var stopWorker = make(chan struct{})
+
+func runInBackground(f func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		f()
+	}()
+}
+
+// Identifies this server in the leases it takes on resolution jobs
+var instanceId = newInstanceId()
+
+func newInstanceId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), ksuid.New().String())
+}
+
+// Time to wait before the next attempt, doubling after every failed one
+func resolutionBackoff(attempts int32) time.Duration {
+	backoff := RESOLUTION_BACKOFF
+	for i := int32(1); i < attempts; i++ {
+		backoff *= 2
+		if backoff >= MAX_RESOLUTION_BACKOFF {
+			return MAX_RESOLUTION_BACKOFF
+		}
+	}
+	return backoff
+}
+
+// Records the outcome of an attempt at a job, giving up on it once it has run out of attempts
+func recordAttempt(job models.ResolutionJob, err error, now time.Time) models.ResolutionJob {
+	job.Attempts += 1
+	if err == nil {
+		job.Status = models.DONE
+		job.LastError = ""
+		return job
+	}
+	job.LastError = err.Error()
+	if job.Attempts >= MAX_RESOLUTION_ATTEMPTS {
+		job.Status = models.DEAD
+	} else {
+		job.NextAttempt = now.Add(resolutionBackoff(job.Attempts))
+	}
+	return job
+}
+
+func runResolutionJob(job models.ResolutionJob) {
+	// Every attempt starts a trace of its own
+	span, ctx := tracing.StartSpan(context.Background(), "resolution job")
+	span.SetTag("game.id", job.GameId)
+	span.SetTag("job.attempt", job.Attempts+1)
+	defer span.Finish()
+
+	start := time.Now()
+	err := ResolveGame(ctx, job.GameId)
+	job = recordAttempt(job, err, time.Now())
+	resolutionsTotal.Inc(resolutionOutcome(job))
+	resolutionDuration.Observe(time.Since(start).Seconds(), resolutionOutcome(job))
+	logger := logging.FromContext(ctx).With("gameId", job.GameId, "attempts", job.Attempts)
+	switch job.Status {
+	case models.DEAD:
+		logger.Error("resolution failed for the last time", "error", job.LastError)
+	case models.PENDING:
+		logger.Warn("resolution failed", "error", job.LastError, "nextAttempt", job.NextAttempt)
+	}
+	err = repository.ReleaseResolutionJob(job, instanceId)
+	if err != nil {
+		logger.Error("failed to update resolution job", "error", err)
+	}
+}
+
+// Resolves a game as soon as it ends, unless another server got to it first. Jobs missed here, such as those of
+// games on a server that went down, are picked up by the worker of any server.
+func resolveEndedGame(gameId string) {
+	job, claimed, err := repository.ClaimResolutionJob(instanceId, gameId, time.Now(), RESOLUTION_LEASE)
+	if err != nil {
+		logging.Default().Error("failed to claim resolution job", "gameId", gameId, "error", err)
+		return
+	}
+	if claimed {
+		runResolutionJob(job)
+	}
+}
+
+func runDueResolutionJobs() {
+	jobs, err := repository.ClaimDueResolutionJobs(instanceId, time.Now(), RESOLUTION_LEASE, RESOLUTION_BATCH)
+	if err != nil {
+		logging.Default().Error("failed to claim due resolution jobs", "error", err)
+		return
+	}
+	for _, job := range jobs {
+		runResolutionJob(job)
+	}
+}
+
+// Runs due resolutions on every server, started once the database is set up
+func StartResolutionWorker() {
+	runInBackground(func() {
+		ticker := time.NewTicker(RESOLUTION_POLL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopWorker:
+				return
+			case <-ticker.C:
+				runDueResolutionJobs()
+			}
+		}
+	})
+}
+
+// Stops scheduling and running resolutions, then waits for the ones already running to finish. Jobs left unfinished
+// when the context is done keep their lease until it expires, after which another server takes them over.
+func Shutdown(ctx context.Context) error {
+	Controller.Stop()
+	close(stopWorker)
+	return waitForBackground(ctx)
+}
+
+func waitForBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Puts a failed resolution back in the queue with a fresh set of attempts
+func RetryResolution(ctx context.Context, gameId string) (job models.ResolutionJob, err error) {
+	job, err = repository.QueryResolutionJob(ctx, gameId)
+	if err != nil {
+		return
+	}
+	if job.Status != models.DEAD {
+		err = apperrors.Validation("only failed resolutions can be retried").With("status", job.Status)
+		return
+	}
+	job.Status = models.PENDING
+	job.Attempts = 0
+	job.LastError = ""
+	job.NextAttempt = time.Now()
+	job.LockedBy = ""
+	job.LockedUntil = time.Time{}
+	retried, err := repository.RetryDeadResolutionJob(job)
+	if err == nil && !retried {
+		// Retried by someone else since it was loaded
+		err = apperrors.Validation("only failed resolutions can be retried")
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"zerosum/models"
+)
+
+func TestResolutionBackoff(t *testing.T) {
+	if backoff := resolutionBackoff(1); backoff != RESOLUTION_BACKOFF {
+		t.Errorf("Expected first retry after %v, got %v", RESOLUTION_BACKOFF, backoff)
+	}
+	if backoff := resolutionBackoff(3); backoff != 4*RESOLUTION_BACKOFF {
+		t.Errorf("Expected backoff to double after every attempt, got %v", backoff)
+	}
+	if backoff := resolutionBackoff(100); backoff != MAX_RESOLUTION_BACKOFF {
+		t.Errorf("Expected backoff to be capped at %v, got %v", MAX_RESOLUTION_BACKOFF, backoff)
+	}
+}
+
+func TestRecordAttempt(t *testing.T) {
+	now := time.Now()
+	job := models.ResolutionJob{GameId: "g1", Status: models.PENDING}
+
+	job = recordAttempt(job, errors.New("connection reset"), now)
+	if job.Status != models.PENDING || job.Attempts != 1 || job.LastError != "connection reset" {
+		t.Errorf("Expected failed job to stay pending, got %+v", job)
+	}
+	if !job.NextAttempt.Equal(now.Add(RESOLUTION_BACKOFF)) {
+		t.Errorf("Expected next attempt after backoff, got %s", job.NextAttempt)
+	}
+
+	job = recordAttempt(job, nil, now)
+	if job.Status != models.DONE || job.LastError != "" {
+		t.Errorf("Expected successful job to be done, got %+v", job)
+	}
+
+	job = models.ResolutionJob{GameId: "g1", Status: models.PENDING, Attempts: MAX_RESOLUTION_ATTEMPTS - 1}
+	job = recordAttempt(job, errors.New("connection reset"), now)
+	if job.Status != models.DEAD {
+		t.Errorf("Expected job out of attempts to be dead, got %+v", job)
+	}
+}
+
+func TestWaitForBackground(t *testing.T) {
+	finished := false
+	runInBackground(func() {
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+	})
+	if err := waitForBackground(context.Background()); err != nil || !finished {
+		t.Errorf("Expected background work to finish, got %v", err)
+	}
+
+	// Work that is still running when the context is done is left behind
+	release := make(chan struct{})
+	defer close(release)
+	runInBackground(func() {
+		<-release
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := waitForBackground(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+}
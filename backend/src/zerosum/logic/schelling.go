@@ -0,0 +1,82 @@
+package logic
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"zerosum/apperrors"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+// Schelling point game, players answer a question in free text and the largest group of matching answers wins.
+// Options are not set by the creator, each distinct answer becomes an option when it is first given.
+type schellingMode struct{}
+
+func (m schellingMode) Name() models.GameMode {
+	return models.SCHELLING
+}
+
+func (m schellingMode) ValidateGame(game *models.Game) (err error) {
+	if len(game.Options) > 0 {
+		err = apperrors.Validation("free text game does not take options")
+	}
+	return
+}
+
+func (m schellingMode) ValidateVote(game *models.Game, vote *models.Vote) (err error) {
+	if NormaliseAnswer(vote.Answer) == "" {
+		err = apperrors.Validation("no answer specified")
+	} else if utf8.RuneCountInString(strings.TrimSpace(vote.Answer)) > MAX_OPTION_LENGTH {
+		err = ErrAnswerTooLong
+	} else if vote.OptionId != "" || len(vote.Allocations) > 0 {
+		err = apperrors.Validation("game does not accept options")
+	} else if vote.Number != nil {
+		err = apperrors.Validation("game does not accept numbers")
+	}
+	return
+}
+
+func (m schellingMode) SelectWinners(game *models.Game, options []models.Option, votes []models.Vote) (outcome Outcome, err error) {
+	// Groups are ranked by the number of players in them, regardless of stakes
+	totals, counts := tallyOptions(options, votes)
+	var winningOptions []int
+	if len(counts) > 0 {
+		winningOptions, _ = resolveMajority(counts)
+	}
+	outcome = optionOutcome(options, votes, totals, counts, winningOptions)
+	return
+}
+
+func (m schellingMode) ComputePayouts(game *models.Game, outcome Outcome, votes []models.Vote) []Payout {
+	return splitPot(outcome, votes)
+}
+
+// Reduces an answer to the form it is grouped by, ignoring case, punctuation and extra whitespace
+func NormaliseAnswer(answer string) string {
+	var words []string
+	for _, word := range strings.Fields(answer) {
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+				return -1
+			}
+			return unicode.ToLower(r)
+		}, word)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Sets the option of a free text vote to the group its answer belongs to, in the transaction that places the vote
+// so that answers of votes that fail are never stored
+func groupAnswer(tx *repository.Tx, vote *models.Vote) (err error) {
+	option, err := tx.GetOrCreateAnswerOption(vote.GameId, strings.TrimSpace(vote.Answer), NormaliseAnswer(vote.Answer))
+	if err == nil {
+		vote.OptionId = option.Id
+		vote.Allocations = []models.Allocation{{GameId: vote.GameId, UserId: vote.UserId, OptionId: option.Id,
+			Money: vote.Money}}
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+	"zerosum/models"
+)
+
+func TestNormaliseAnswer(t *testing.T) {
+	cases := map[string]string{
+		"New York":          "new york",
+		"  new   YORK!! ":   "new york",
+		"New-York":          "newyork",
+		"Times Square, NYC": "times square nyc",
+		"?!":                "",
+	}
+	for answer, expected := range cases {
+		if normalised := NormaliseAnswer(answer); normalised != expected {
+			t.Errorf("Expected %q to normalise to %q, got %q", answer, expected, normalised)
+		}
+	}
+}
+
+func TestSchellingValidateVote(t *testing.T) {
+	mode, _ := GetGameMode(models.SCHELLING)
+	if err := mode.ValidateVote(&models.Game{}, &models.Vote{Answer: "Heads"}); err != nil {
+		t.Errorf("Unexpected error for answer: %v", err)
+	}
+	if err := mode.ValidateVote(&models.Game{}, &models.Vote{Answer: " ... "}); err == nil {
+		t.Errorf("Expected error for answer with only punctuation")
+	}
+	long := &models.Vote{Answer: strings.Repeat("a", MAX_OPTION_LENGTH+1)}
+	if err := mode.ValidateVote(&models.Game{}, long); err != ErrAnswerTooLong {
+		t.Errorf("Expected error %v, got %v", ErrAnswerTooLong, err)
+	}
+}
+
+func TestSchellingMode(t *testing.T) {
+	game := &models.Game{Stakes: models.NO_LIMIT}
+	options := []models.Option{{Id: "heads", AnswerKey: "heads"}, {Id: "tails", AnswerKey: "tails"}}
+	votes := []models.Vote{
+		{UserId: "u1", OptionId: "heads", Money: 500},
+		{UserId: "u2", OptionId: "tails", Money: 100},
+		{UserId: "u3", OptionId: "tails", Money: 100},
+	}
+	mode, _ := GetGameMode(models.SCHELLING)
+	outcome, err := mode.SelectWinners(game, options, votes)
+	if err != nil {
+		t.Fatalf("Failed to select winners: %v", err)
+	}
+	// Largest group wins even though less money is on it
+	if outcome.Options[0].Winner || !outcome.Options[1].Winner {
+		t.Errorf("Expected tails to win, got %+v", outcome.Options)
+	}
+	payouts := payoutsByUser(mode.ComputePayouts(game, outcome, votes))
+	if p := payouts["u2"]; !p.Win || p.Amount != 350 {
+		t.Errorf("Expected u2 to win 350, got %+v", p)
+	}
+}
@@ -0,0 +1,68 @@
+package logic
+
+import (
+	"zerosum/apperrors"
+	"zerosum/models"
+)
+
+// Stake of a fixed stakes game created without an amount, which is what clients that predate amounts stake
+const DEFAULT_FIXED_AMOUNT = 100
+
+// Checks that a new game carries the amounts its stakes type needs, and clears the ones it does not use
+func ValidateStakes(game *models.Game) (err error) {
+	switch game.Stakes {
+	case models.NO_STAKES, models.NO_LIMIT:
+		game.FixedAmount = 0
+		game.MaxAmount = 0
+	case models.FIXED_STAKES:
+		if game.FixedAmount == 0 {
+			game.FixedAmount = DEFAULT_FIXED_AMOUNT
+		}
+		if game.FixedAmount < 0 {
+			err = apperrors.Validation("fixed stakes game requires a positive fixed amount")
+		}
+		game.MaxAmount = 0
+	case models.FIXED_LIMIT:
+		if game.MaxAmount <= 0 {
+			err = apperrors.Validation("fixed limit game requires a positive max amount")
+		}
+		game.FixedAmount = 0
+	default:
+		err = apperrors.Validation("invalid stakes specified")
+	}
+	return
+}
+
+// Returns the amount of money a vote of the given amount actually stakes in the game
+func VoteStake(game models.Game, amount int32) (stake int32, err error) {
+	switch game.Stakes {
+	case models.NO_STAKES:
+		// Free play money vote, nothing is staked and nothing is paid out
+		if amount != 0 {
+			err = apperrors.Validation("no stakes game does not accept money")
+		}
+	case models.FIXED_STAKES:
+		if amount != game.FixedAmount {
+			err = apperrors.Validation("amount must equal the fixed stakes of the game")
+		}
+		stake = amount
+	case models.FIXED_LIMIT:
+		if amount <= 0 {
+			err = apperrors.Validation("invalid amount specified")
+		} else if amount > game.MaxAmount {
+			err = apperrors.Validation("amount exceeds the limit of the game")
+		}
+		stake = amount
+	case models.NO_LIMIT:
+		if amount <= 0 {
+			err = apperrors.Validation("invalid amount specified")
+		}
+		stake = amount
+	default:
+		err = apperrors.Validation("invalid stakes specified")
+	}
+	if err != nil {
+		stake = 0
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package logic
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+	"zerosum/apperrors"
+	"zerosum/models"
+)
+
+const (
+	MAX_TOPIC_LENGTH  = 280
+	MAX_OPTION_LENGTH = 100
+	MAX_OPTIONS       = 20
+	MIN_DURATION      = time.Minute
+	MAX_DURATION      = 7 * 24 * time.Hour
+)
+
+// Errors returned when a new game or vote breaks one of the rules below, so that callers can tell them apart
+var (
+	ErrEmptyTopic           = apperrors.Validation("empty topic").With("field", "topic")
+	ErrTopicTooLong         = apperrors.Validation("topic too long").With("field", "topic").With("max", MAX_TOPIC_LENGTH)
+	ErrDurationTooShort     = apperrors.Validation("duration too short").With("field", "duration")
+	ErrDurationTooLong      = apperrors.Validation("duration too long").With("field", "duration")
+	ErrEmptyOption          = apperrors.Validation("empty option").With("field", "options")
+	ErrOptionTooLong        = apperrors.Validation("option too long").With("field", "options").With("max", MAX_OPTION_LENGTH)
+	ErrDuplicateOption      = apperrors.Validation("duplicate option").With("field", "options")
+	ErrTooManyOptions       = apperrors.Validation("too many options").With("field", "options").With("max", MAX_OPTIONS)
+	ErrGameEnded            = apperrors.GameClosed("game has ended")
+	ErrVotesLocked          = apperrors.GameClosed("votes are locked")
+	ErrOptionNotInGame      = apperrors.Validation("option does not belong to game").With("field", "optionId")
+	ErrOptionAndAllocations = apperrors.Validation("option and allocations both specified").With("field", "allocations")
+	ErrAllocationMismatch   = apperrors.Validation("allocations do not match amount").With("field", "allocations")
+	ErrAnswerTooLong        = apperrors.Validation("answer too long").With("field", "answer").With("max", MAX_OPTION_LENGTH)
+)
+
+// Checks every rule a new game must follow before anything is allocated for it. Settings the game leaves out are
+// filled in with their defaults.
+func ValidateNewGame(game *models.Game) (err error) {
+	game.Topic = strings.TrimSpace(game.Topic)
+	if game.Topic == "" {
+		return ErrEmptyTopic
+	}
+	if utf8.RuneCountInString(game.Topic) > MAX_TOPIC_LENGTH {
+		return ErrTopicTooLong
+	}
+
+	duration := game.EndTime.Sub(game.StartTime)
+	if duration < MIN_DURATION {
+		return ErrDurationTooShort
+	}
+	if duration > MAX_DURATION {
+		return ErrDurationTooLong
+	}
+
+	if len(game.Options) > MAX_OPTIONS {
+		return ErrTooManyOptions
+	}
+	seen := make(map[string]bool)
+	for i := range game.Options {
+		game.Options[i].Body = strings.TrimSpace(game.Options[i].Body)
+		body := game.Options[i].Body
+		if body == "" {
+			return ErrEmptyOption
+		}
+		if utf8.RuneCountInString(body) > MAX_OPTION_LENGTH {
+			return ErrOptionTooLong
+		}
+		// Options that only differ in case would be impossible to tell apart
+		if seen[strings.ToLower(body)] {
+			return ErrDuplicateOption
+		}
+		seen[strings.ToLower(body)] = true
+	}
+
+	err = ValidateStakes(game)
+	if err != nil {
+		return
+	}
+	err = ValidateThresholds(game)
+	if err != nil {
+		return
+	}
+	mode, err := GetGameMode(game.GameMode)
+	if err != nil {
+		return
+	}
+	return mode.ValidateGame(game)
+}
+
+// Checks every rule a vote must follow before its stake is taken, given the options of the game it is placed in
+func ValidateNewVote(game models.Game, options []models.Option, vote *models.Vote, now time.Time) (err error) {
+	if game.Resolved || game.Voided || !now.Before(game.EndTime) {
+		return ErrGameEnded
+	}
+
+	inGame := make(map[string]bool)
+	for _, option := range options {
+		inGame[option.Id] = true
+	}
+	total := int32(0)
+	for _, allocation := range vote.Allocations {
+		if !inGame[allocation.OptionId] {
+			return ErrOptionNotInGame
+		}
+		total += allocation.Money
+	}
+	if len(vote.Allocations) > 0 && total != vote.Money {
+		return ErrAllocationMismatch
+	}
+
+	mode, err := GetGameMode(game.GameMode)
+	if err != nil {
+		return
+	}
+	return mode.ValidateVote(&game, vote)
+}
@@ -0,0 +1,90 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"zerosum/models"
+)
+
+func newTestGame(topic string, duration time.Duration, options ...string) *models.Game {
+	now := time.Now()
+	game := &models.Game{
+		Topic:           topic,
+		StartTime:       now,
+		EndTime:         now.Add(duration),
+		GameMode:        models.MAJORITY,
+		Stakes:          models.NO_LIMIT,
+		MinParticipants: DEFAULT_MIN_PARTICIPANTS,
+	}
+	for _, option := range options {
+		game.Options = append(game.Options, models.Option{Body: option})
+	}
+	return game
+}
+
+func TestValidateNewGame(t *testing.T) {
+	cases := []struct {
+		name string
+		game *models.Game
+		err  error
+	}{
+		{"valid", newTestGame("Cats or dogs?", time.Hour, "Cats", "Dogs"), nil},
+		{"empty topic", newTestGame("  ", time.Hour, "Cats", "Dogs"), ErrEmptyTopic},
+		{"long topic", newTestGame(strings.Repeat("a", MAX_TOPIC_LENGTH+1), time.Hour, "Cats", "Dogs"), ErrTopicTooLong},
+		{"zero duration", newTestGame("Cats or dogs?", 0, "Cats", "Dogs"), ErrDurationTooShort},
+		{"negative duration", newTestGame("Cats or dogs?", -time.Hour, "Cats", "Dogs"), ErrDurationTooShort},
+		{"long duration", newTestGame("Cats or dogs?", MAX_DURATION+time.Minute, "Cats", "Dogs"), ErrDurationTooLong},
+		{"empty option", newTestGame("Cats or dogs?", time.Hour, "Cats", " "), ErrEmptyOption},
+		{"long option", newTestGame("Cats or dogs?", time.Hour, "Cats", strings.Repeat("a", MAX_OPTION_LENGTH+1)), ErrOptionTooLong},
+		{"duplicate option", newTestGame("Cats or dogs?", time.Hour, "Cats", "cats "), ErrDuplicateOption},
+	}
+	for _, c := range cases {
+		if err := ValidateNewGame(c.game); err != c.err {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.err, err)
+		}
+	}
+
+	many := newTestGame("Pick a number", time.Hour)
+	for i := 0; i <= MAX_OPTIONS; i++ {
+		many.Options = append(many.Options, models.Option{Body: strings.Repeat("a", i+1)})
+	}
+	if err := ValidateNewGame(many); err != ErrTooManyOptions {
+		t.Errorf("Expected error %v, got %v", ErrTooManyOptions, err)
+	}
+}
+
+func TestValidateNewVote(t *testing.T) {
+	now := time.Now()
+	game := models.Game{GameMode: models.MAJORITY, Stakes: models.NO_LIMIT, EndTime: now.Add(time.Hour)}
+	vote := func(optionId string) *models.Vote {
+		return &models.Vote{Money: 100, OptionId: optionId, Allocations: []models.Allocation{{OptionId: optionId, Money: 100}}}
+	}
+
+	if err := ValidateNewVote(game, testOptions, vote("a"), now); err != nil {
+		t.Errorf("Unexpected error for valid vote: %v", err)
+	}
+	if err := ValidateNewVote(game, testOptions, vote("z"), now); err != ErrOptionNotInGame {
+		t.Errorf("Expected error %v, got %v", ErrOptionNotInGame, err)
+	}
+	if err := ValidateNewVote(game, testOptions, vote("a"), now.Add(time.Hour)); err != ErrGameEnded {
+		t.Errorf("Expected error %v, got %v", ErrGameEnded, err)
+	}
+	split := &models.Vote{Money: 100, Allocations: []models.Allocation{{OptionId: "a", Money: 50}, {OptionId: "b", Money: 60}}}
+	if err := ValidateNewVote(game, testOptions, split, now); err != ErrAllocationMismatch {
+		t.Errorf("Expected error %v, got %v", ErrAllocationMismatch, err)
+	}
+}
+
+func TestValidateStakesDefaultsFixedAmount(t *testing.T) {
+	game := newTestGame("Cats or dogs?", time.Hour, "Cats", "Dogs")
+	game.Stakes = models.FIXED_STAKES
+	if err := ValidateStakes(game); err != nil || game.FixedAmount != DEFAULT_FIXED_AMOUNT {
+		t.Errorf("Expected fixed amount to default to %d, got %d, %v", DEFAULT_FIXED_AMOUNT, game.FixedAmount, err)
+	}
+
+	game.FixedAmount = -5
+	if err := ValidateStakes(game); err == nil {
+		t.Errorf("Expected negative fixed amount to be rejected")
+	}
+}
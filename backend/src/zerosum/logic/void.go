@@ -0,0 +1,127 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"zerosum/apperrors"
+	"zerosum/models"
+	"zerosum/push"
+	"zerosum/repository"
+)
+
+const DEFAULT_MIN_PARTICIPANTS = 2
+
+// Checks the thresholds a game must reach to be resolved instead of voided
+func ValidateThresholds(game *models.Game) (err error) {
+	if game.MinParticipants < 1 {
+		err = apperrors.Validation("minimum participants must be at least 1")
+	} else if game.MinPot < 0 {
+		err = apperrors.Validation("minimum pot cannot be negative")
+	} else if game.MinPot > 0 && game.Stakes == models.NO_STAKES {
+		err = apperrors.Validation("no stakes game cannot have a minimum pot")
+	}
+	return
+}
+
+// Returns why a game that has ended should be voided instead of resolved, if it did not reach its thresholds
+func checkThresholds(game models.Game, votes []models.Vote) (reason models.VoidReason, void bool) {
+	// Nobody can win a game without players, whatever its thresholds
+	minParticipants := game.MinParticipants
+	if minParticipants < 1 {
+		minParticipants = 1
+	}
+	pot := int32(0)
+	for _, vote := range votes {
+		pot += vote.Money
+	}
+
+	if int32(len(votes)) < minParticipants {
+		return models.TOO_FEW_PLAYERS, true
+	}
+	if pot < game.MinPot {
+		return models.POT_TOO_SMALL, true
+	}
+	return
+}
+
+// Ends a game without a result, refunding every stake placed on it and notifying its players. Games that have
+// ended can only be voided when allowEnded is set, so that admins can clear a game stuck waiting to be resolved but
+// creators cannot void a game they can see they lost.
+func VoidGame(ctx context.Context, gameId string, reason models.VoidReason, allowEnded bool) (err error) {
+	var notifications []notification
+	err = repository.Transaction(ctx, func(tx *repository.Tx) (err error) {
+		// Claiming the settlement stops the game being resolved as well as voided
+		claimed, err := tx.ClaimSettlement(gameId)
+		if err != nil {
+			return
+		}
+		if !claimed {
+			err = apperrors.GameClosed("game has already ended")
+			return
+		}
+		game, err := tx.LockGame(gameId)
+		if err != nil {
+			return
+		}
+		if game.Resolved || game.Voided {
+			err = apperrors.GameClosed("game has already ended")
+			return
+		}
+		if !allowEnded && !time.Now().Before(game.EndTime) {
+			err = ErrGameEnded
+			return
+		}
+		votes, err := tx.QueryAllGameVotes(models.Game{Id: gameId})
+		if err != nil {
+			return
+		}
+		notifications, err = voidGame(tx, game, votes, reason)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	Controller.RemoveGame(gameId)
+	for _, notif := range notifications {
+		push.SendNotif(ctx, notif.body, notif.userId)
+	}
+	return
+}
+
+func voidGame(tx *repository.Tx, game models.Game, votes []models.Vote, reason models.VoidReason) (notifications []notification, err error) {
+	game.Voided = true
+	game.VoidReason = reason
+	err = tx.UpdateGame(game)
+	if err != nil {
+		return
+	}
+
+	var body string
+	switch reason {
+	case models.TOO_FEW_PLAYERS:
+		body = fmt.Sprintf("[Game Voided] %s did not get enough players", game.Topic)
+	case models.POT_TOO_SMALL:
+		body = fmt.Sprintf("[Game Voided] %s did not reach its minimum pot", game.Topic)
+	default:
+		body = fmt.Sprintf("[Game Cancelled] %s", game.Topic)
+	}
+	for _, vote := range votes {
+		// Votes of a voided game neither win nor lose, and do not count towards stats
+		err = updateVoteResult(tx, vote.UserId, vote.GameId, false, 0, 0)
+		if err != nil {
+			return
+		}
+		if vote.Money > 0 {
+			err = releaseMoney(tx, models.REFUND, vote.UserId, vote.GameId, vote.Money)
+			if err != nil {
+				return
+			}
+			notifications = append(notifications, notification{vote.UserId, fmt.Sprintf("%s, %d has been refunded", body, vote.Money)})
+		} else {
+			notifications = append(notifications, notification{vote.UserId, body})
+		}
+	}
+	return
+}
@@ -0,0 +1,66 @@
+package logic
+
+import (
+	"context"
+	"testing"
+	"time"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+func TestValidateThresholds(t *testing.T) {
+	if err := ValidateThresholds(&models.Game{Stakes: models.NO_LIMIT, MinParticipants: 2, MinPot: 100}); err != nil {
+		t.Errorf("Unexpected error for valid thresholds: %v", err)
+	}
+	if err := ValidateThresholds(&models.Game{Stakes: models.NO_LIMIT}); err == nil {
+		t.Errorf("Expected error for no minimum participants")
+	}
+	if err := ValidateThresholds(&models.Game{Stakes: models.NO_LIMIT, MinParticipants: 1, MinPot: -1}); err == nil {
+		t.Errorf("Expected error for negative minimum pot")
+	}
+	if err := ValidateThresholds(&models.Game{Stakes: models.NO_STAKES, MinParticipants: 1, MinPot: 100}); err == nil {
+		t.Errorf("Expected error for minimum pot in no stakes game")
+	}
+}
+
+func TestCheckThresholds(t *testing.T) {
+	votes := []models.Vote{{UserId: "u1", Money: 100}, {UserId: "u2", Money: 50}}
+	game := models.Game{MinParticipants: 2, MinPot: 150}
+	if reason, void := checkThresholds(game, votes); void {
+		t.Errorf("Expected game to reach its thresholds, got %s", reason)
+	}
+	if reason, _ := checkThresholds(game, votes[:1]); reason != models.TOO_FEW_PLAYERS {
+		t.Errorf("Expected game with one player to be voided for too few players, got %s", reason)
+	}
+	game.MinPot = 200
+	if reason, _ := checkThresholds(game, votes); reason != models.POT_TOO_SMALL {
+		t.Errorf("Expected game to be voided for its pot, got %s", reason)
+	}
+
+	// Games created before thresholds existed are still voided when nobody played
+	if reason, _ := checkThresholds(models.Game{}, nil); reason != models.TOO_FEW_PLAYERS {
+		t.Errorf("Expected game without players to be voided, got %s", reason)
+	}
+	if _, void := checkThresholds(models.Game{}, votes[:1]); void {
+		t.Errorf("Expected game without thresholds to resolve with one player")
+	}
+}
+
+func TestVoidEndedGame(t *testing.T) {
+	requireTestDB(t)
+	host := createTestUser(t)
+	defer repository.DeleteUser(host)
+	game := createTestGame(t, host.Id)
+	game.EndTime = time.Now().Add(-time.Minute)
+	if err := repository.UpdateGame(game); err != nil {
+		t.Fatalf("Failed to end game: %v", err)
+	}
+
+	// Ended games waiting to be resolved can only be voided by admins
+	if err := VoidGame(context.Background(), game.Id, models.CANCELLED, false); err != ErrGameEnded {
+		t.Errorf("Expected error %v, got %v", ErrGameEnded, err)
+	}
+	if err := VoidGame(context.Background(), game.Id, models.CANCELLED, true); err != nil {
+		t.Errorf("Expected ended game to be voided, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package logic
+
+import (
+	"context"
+	"time"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+// Votes can only be changed or withdrawn while the game is running, unless the creator locked them
+func checkVotesOpen(game models.Game, now time.Time) (err error) {
+	if game.Resolved || game.Voided || !now.Before(game.EndTime) {
+		err = ErrGameEnded
+	} else if game.LockVotes {
+		err = ErrVotesLocked
+	}
+	return
+}
+
+// Takes a player's stake and records their vote, all at once so that a vote that fails to be created costs nothing.
+// Retrying with the same idempotency key does nothing, as long as it is for the same game.
+func PlaceVote(ctx context.Context, newVote models.Vote, idempotencyKey string) error {
+	gameId, replayed, err := runIdempotent(ctx, newVote.UserId, idempotencyKey, ADD_VOTE_OPERATION,
+		func(tx *repository.Tx) (string, error) {
+			return newVote.GameId, placeVote(tx, newVote)
+		})
+	if err == nil && replayed && gameId != newVote.GameId {
+		err = ErrIdempotencyKeyReused
+	}
+	return err
+}
+
+// Whether a vote on the game was already placed with the idempotency key, checked before the vote is validated so
+// that a retry of a vote placed just before the game ended is answered with that vote rather than turned down
+func VotePlaced(ctx context.Context, userId string, gameId string, idempotencyKey string) (placed bool, err error) {
+	if idempotencyKey == "" {
+		return
+	}
+	existing, found, err := repository.QueryIdempotencyKey(ctx, userId, idempotencyKey, IDEMPOTENCY_WINDOW)
+	if err != nil || !found {
+		return
+	}
+	if existing.Operation != ADD_VOTE_OPERATION || existing.ResultId != gameId {
+		err = ErrIdempotencyKeyReused
+		return
+	}
+	placed = true
+	return
+}
+
+func placeVote(tx *repository.Tx, newVote models.Vote) (err error) {
+	game, err := tx.LockGame(newVote.GameId)
+	if err != nil {
+		return
+	}
+	if game.Resolved || game.Voided || !time.Now().Before(game.EndTime) {
+		err = ErrGameEnded
+		return
+	}
+
+	err = stakeMoney(tx, newVote.UserId, newVote.GameId, newVote.Money)
+	if err != nil {
+		return
+	}
+	err = allocateExp(tx, newVote.UserId, VOTE_EXP)
+	if err != nil {
+		return
+	}
+	if newVote.Answer != "" {
+		// Free text answers are grouped into options as they come in
+		err = groupAnswer(tx, &newVote)
+		if err != nil {
+			return
+		}
+	}
+	err = tx.CreateVote(newVote)
+	return
+}
+
+// Replaces a player's vote with a new choice, refunding the old stake and taking the new one. Vote exp was already
+// given for the original vote and stats are only counted when the game is settled, so neither changes here.
+func ChangeVote(ctx context.Context, newVote models.Vote) error {
+	return repository.Transaction(ctx, func(tx *repository.Tx) (err error) {
+		game, err := tx.LockGame(newVote.GameId)
+		if err != nil {
+			return
+		}
+		err = checkVotesOpen(game, time.Now())
+		if err != nil {
+			return
+		}
+		vote, err, _ := tx.QueryVote(models.Vote{GameId: newVote.GameId, UserId: newVote.UserId})
+		if err != nil {
+			return
+		}
+
+		// Only the difference is moved, so a player can shift money between options without having it twice
+		if change := vote.Money - newVote.Money; change > 0 {
+			err = releaseMoney(tx, models.REFUND, newVote.UserId, newVote.GameId, change)
+		} else if change < 0 {
+			err = stakeMoney(tx, newVote.UserId, newVote.GameId, -change)
+		}
+		if err != nil {
+			return
+		}
+		if newVote.Answer != "" {
+			err = groupAnswer(tx, &newVote)
+			if err != nil {
+				return
+			}
+		}
+		err = tx.ReplaceVote(newVote)
+		return
+	})
+}
+
+// Removes a player's vote, refunding their stake and taking back the exp given for voting
+func WithdrawVote(ctx context.Context, userId string, gameId string) error {
+	return repository.Transaction(ctx, func(tx *repository.Tx) (err error) {
+		game, err := tx.LockGame(gameId)
+		if err != nil {
+			return
+		}
+		err = checkVotesOpen(game, time.Now())
+		if err != nil {
+			return
+		}
+		vote, err, _ := tx.QueryVote(models.Vote{GameId: gameId, UserId: userId})
+		if err != nil {
+			return
+		}
+
+		err = releaseMoney(tx, models.REFUND, userId, gameId, vote.Money)
+		if err != nil {
+			return
+		}
+		err = allocateExp(tx, userId, -VOTE_EXP)
+		if err != nil {
+			return
+		}
+		err = tx.DeleteVote(vote)
+		return
+	})
+}
@@ -0,0 +1,67 @@
+package logic
+
+import (
+	"context"
+	"testing"
+	"time"
+	"zerosum/apperrors"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+func TestCheckVotesOpen(t *testing.T) {
+	now := time.Now()
+	running := models.Game{EndTime: now.Add(time.Minute)}
+	if err := checkVotesOpen(running, now); err != nil {
+		t.Errorf("Unexpected error for running game: %v", err)
+	}
+	if err := checkVotesOpen(models.Game{EndTime: now}, now); err == nil {
+		t.Errorf("Expected error for game at its end time")
+	}
+	if err := checkVotesOpen(models.Game{EndTime: now.Add(time.Minute), Resolved: true}, now); err == nil {
+		t.Errorf("Expected error for resolved game")
+	}
+	if err := checkVotesOpen(models.Game{EndTime: now.Add(time.Minute), Voided: true}, now); err == nil {
+		t.Errorf("Expected error for voided game")
+	}
+	if err := checkVotesOpen(models.Game{EndTime: now.Add(time.Minute), LockVotes: true}, now); err == nil {
+		t.Errorf("Expected error for game with locked votes")
+	}
+}
+
+func TestFailedVotesStoreNoAnswers(t *testing.T) {
+	requireTestDB(t)
+	host := createTestUser(t)
+	defer repository.DeleteUser(host)
+	game, err := repository.CreateGame(models.Game{
+		Topic:           "Heads or tails?",
+		UserId:          host.Id,
+		StartTime:       time.Now(),
+		EndTime:         time.Now().Add(time.Hour),
+		Stakes:          models.NO_LIMIT,
+		GameMode:        models.SCHELLING,
+		MinParticipants: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+
+	ctx := context.Background()
+	vote := models.Vote{GameId: game.Id, UserId: host.Id, Answer: "Heads", Money: 100}
+	if err := PlaceVote(ctx, vote, ""); err != nil {
+		t.Fatalf("Failed to place vote: %v", err)
+	}
+	// Answers of votes that are turned down are rolled back along with them
+	vote.Answer = "Tails"
+	if err := PlaceVote(ctx, vote, ""); apperrors.CodeOf(err) != apperrors.ALREADY_VOTED {
+		t.Errorf("Expected second vote to be turned down, got %v", err)
+	}
+	vote.Money = repository.STARTING_MONEY * 2
+	if err := ChangeVote(ctx, vote); apperrors.CodeOf(err) != apperrors.INSUFFICIENT_FUNDS {
+		t.Errorf("Expected change beyond balance to be turned down, got %v", err)
+	}
+	options, err := repository.QueryGameOptions(context.Background(), game)
+	if err != nil || len(options) != 1 || options[0].AnswerKey != "heads" {
+		t.Errorf("Expected only the answer of the placed vote to be stored, got %+v, %v", options, err)
+	}
+}
@@ -1,27 +1,50 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/gobuffalo/packr"
 	"github.com/gorilla/mux"
 	"github.com/graph-gophers/graphql-go"
-	"github.com/graph-gophers/graphql-go/relay"
+	"github.com/graph-gophers/graphql-go/trace"
+	"github.com/opentracing/opentracing-go"
 	"github.com/rs/cors"
 	"github.com/urfave/negroni"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 	"zerosum/auth"
+	"zerosum/health"
+	"zerosum/logging"
 	"zerosum/logic"
+	"zerosum/metrics"
 	"zerosum/push"
 	"zerosum/repository"
 	"zerosum/resolvers"
+	"zerosum/tracing"
 )
 
+// Longest to wait for requests and resolutions in flight to finish when shutting down
+const SHUTDOWN_TIMEOUT = 30 * time.Second
+
+var gameModeEnum = regexp.MustCompile(`(?s)enum GameMode \{.*?\}`)
+
 func readSchema() (string, error) {
 	box := packr.NewBox("./models/schema")
-	return box.MustString("schema.graphql")
+	s, err := box.MustString("schema.graphql")
+	if err != nil {
+		return s, err
+	}
+	// The GameMode enum is generated from the game modes registered in logic
+	var modes []string
+	for _, mode := range logic.GameModeNames() {
+		modes = append(modes, "    "+string(mode))
+	}
+	return gameModeEnum.ReplaceAllLiteralString(s, "enum GameMode {\n"+strings.Join(modes, "\n")+"\n}"), nil
 }
 
 func NewGqlHandler(rootResolver *resolvers.Resolver) (http.Handler, error) {
@@ -29,8 +52,10 @@ func NewGqlHandler(rootResolver *resolvers.Resolver) (http.Handler, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read graphql schema: %v", err)
 	}
-	schema := graphql.MustParseSchema(s, rootResolver)
-	handler := &relay.Handler{Schema: schema}
+	// Traces each operation and each field that has a resolver method
+	tracer := resolvers.OperationTracer{Tracer: trace.OpenTracingTracer{}}
+	schema := graphql.MustParseSchema(s, rootResolver, graphql.Tracer(tracer))
+	handler := &resolvers.Handler{Schema: schema}
 	return handler, err
 }
 
@@ -47,16 +72,50 @@ func redirectTLSHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "https://"+r.Host+r.RequestURI, http.StatusMovedPermanently)
 }
 
+// Every server restores timers for all unresolved games, so that games are resolved promptly wherever they were
+// created. Only one of them resolves each game, as the resolution job is leased to whichever claims it first.
 func restoreGames() {
 	games := repository.SearchUnresolvedGames()
 	if len(games) > 0 {
-		log.Printf("Restoring %d games...", len(games))
-		for _, game := range games {
-			logic.Controller.AddGame(&game)
+		logging.Default().Info("restoring games", "count", len(games))
+		for index := range games {
+			logic.Controller.AddGame(&games[index])
 		}
 	} else {
-		log.Print("No games to restore...")
+		logging.Default().Info("no games to restore")
+	}
+}
+
+func serve(server *http.Server, serveFn func() error) {
+	go func() {
+		if err := serveFn(); err != http.ErrServerClosed {
+			logging.Default().Error("listener failed", "addr", server.Addr, "error", err)
+			os.Exit(1)
+		}
+	}()
+}
+
+// Blocks until the process is told to stop, then stops accepting requests, drains the servers and the game loop and
+// closes the DB
+func waitForShutdown(servers ...*http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	logging.Default().Info("shutting down", "signal", (<-signals).String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logging.Default().Error("failed to drain requests", "addr", server.Addr, "error", err)
+		}
+	}
+	if err := logic.Shutdown(ctx); err != nil {
+		logging.Default().Error("failed to drain resolutions", "error", err)
+	}
+	if err := repository.CloseDB(); err != nil {
+		logging.Default().Error("failed to close DB", "error", err)
 	}
+	logging.Default().Info("shut down")
 }
 
 func main() {
@@ -66,17 +125,19 @@ func main() {
 	}
 	err := repository.InitTestDB()
 	if err != nil {
-		log.Printf("Failed to init DB: %v", err)
+		logging.Default().Error("failed to init DB", "error", err)
 	}
+	// No tracing backend is set up yet, so spans are dropped rather than held in memory
+	tracing.Init(opentracing.NoopTracer{})
 	// Set up Game Logic
 	err = logic.SetUpHats()
 	if err != nil {
-		log.Printf("Failed to set up hats: %v", err)
+		logging.Default().Error("failed to set up hats", "error", err)
 	}
 	rootResolver := resolvers.Resolver{}
 	gqlHandler, err := NewGqlHandler(&rootResolver)
 	if err != nil {
-		log.Printf("Failed to init graphql handler: %v", err)
+		logging.Default().Error("failed to init graphql handler", "error", err)
 	}
 	httpClient := http.Client{
 		Timeout: time.Second * 10,
@@ -92,6 +153,9 @@ func main() {
 		&httpClient,
 	)
 	restoreGames()
+	logic.Controller.Start()
+	logic.StartResolutionWorker()
+	logic.StartMetricsRefresh()
 	staticFiles := packr.NewBox("./static")
 
 	authRouter := mux.NewRouter()
@@ -102,6 +166,15 @@ func main() {
 
 	router := mux.NewRouter()
 	router.HandleFunc("/login/facebook", auth.FbLoginHandler).Methods("POST")
+	// Alive as long as the game loop is running, but only ready to serve while the DB is reachable too
+	router.Handle("/healthz", health.Handler(map[string]health.Check{
+		"gameLoop": logic.Controller.Ping,
+	}))
+	router.Handle("/readyz", health.Handler(map[string]health.Check{
+		"db":       repository.Ping,
+		"gameLoop": logic.Controller.Ping,
+	}))
+	router.Handle("/metrics", metrics.Handler())
 	router.PathPrefix("/static").Handler(http.StripPrefix("/static", http.FileServer(staticFiles)))
 	if DEBUG {
 		router.Handle("/noauth/gql", gqlHandler)
@@ -110,21 +183,25 @@ func main() {
 	// on `router`
 	router.PathPrefix("/").Handler(an)
 	// Set up middleware in front of main router
-	n := negroni.New(negroni.NewRecovery(), negroni.NewLogger(), GetCorsMiddleware())
+	n := negroni.New(negroni.NewRecovery(), negroni.HandlerFunc(tracing.NegroniMiddleware),
+		negroni.HandlerFunc(logging.NegroniMiddleware), GetCorsMiddleware())
 	n.UseHandler(router)
 
 	if DEBUG {
-		n.Run(":80")
+		server := &http.Server{Addr: ":80", Handler: n}
+		serve(server, server.ListenAndServe)
+		waitForShutdown(server)
 	} else {
 		// Serve on HTTPS only
 		sslCertPath := os.Getenv("SSL_CERT_PATH")
 		sslKeyPath := os.Getenv("SSL_KEY_PATH")
-		go func() {
-			// Redirect HTTP -> HTTPS
-			if err := http.ListenAndServe(":80", http.HandlerFunc(redirectTLSHandler)); err != nil {
-				log.Fatalf("HTTP (redirect handler) listener error: %v", err)
-			}
-		}()
-		log.Fatalf("HTTPS listener error: %v", http.ListenAndServeTLS(":443", sslCertPath, sslKeyPath, n))
+		// Redirect HTTP -> HTTPS
+		redirectServer := &http.Server{Addr: ":80", Handler: http.HandlerFunc(redirectTLSHandler)}
+		serve(redirectServer, redirectServer.ListenAndServe)
+		server := &http.Server{Addr: ":443", Handler: n}
+		serve(server, func() error {
+			return server.ListenAndServeTLS(sslCertPath, sslKeyPath)
+		})
+		waitForShutdown(redirectServer, server)
 	}
 }
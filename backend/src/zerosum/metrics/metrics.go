@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"zerosum/logging"
+)
+
+// Buckets in seconds for timing requests and resolutions
+var DEFAULT_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics registered here are served by Handler in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var Default = &Registry{}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic("metric registered twice: " + m.name())
+		}
+	}
+	r.metrics = append(r.metrics, m)
+	sort.Slice(r.metrics, func(i, j int) bool { return r.metrics[i].name() < r.metrics[j].name() })
+}
+
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	})
+}
+
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+/* LABELS */
+type labelled struct {
+	metricName string
+	help       string
+	labels     []string
+	mu         sync.Mutex
+	keys       []string // label values of each series, joined, in the order they were first seen
+}
+
+func (l *labelled) name() string {
+	return l.metricName
+}
+
+func (l *labelled) key(values []string) string {
+	if len(values) != len(l.labels) {
+		panic(fmt.Sprintf("metric %s takes %d labels, got %d", l.metricName, len(l.labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+func (l *labelled) writeHeader(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", l.metricName, l.help, l.metricName, kind)
+}
+
+// Formats the labels of a series, with an extra label such as a histogram bucket appended when given
+func (l *labelled) format(key string, extra ...string) string {
+	var pairs []string
+	if len(l.labels) > 0 {
+		for i, value := range strings.Split(key, "\x00") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", l.labels[i], value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(value)
+}
+
+/* COUNTER */
+// Count of events, split into a series for each combination of label values
+type Counter struct {
+	labelled
+	values map[string]float64
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{labelled: labelled{metricName: name, help: help, labels: labels}, values: make(map[string]float64)}
+	Default.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(value float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.values[key] += value
+}
+
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, key := range c.keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.format(key), formatValue(c.values[key]))
+	}
+}
+
+/* HISTOGRAM */
+// Distribution of observed values, split into a series for each combination of label values
+type Histogram struct {
+	labelled
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // cumulative count of observations up to each bucket
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		labelled: labelled{metricName: name, help: help, labels: labels},
+		buckets:  buckets,
+		series:   make(map[string]*histogramSeries),
+	}
+	Default.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+		h.keys = append(h.keys, key)
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i] += 1
+		}
+	}
+	series.count += 1
+	series.sum += value
+}
+
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if series, ok := h.series[key]; ok {
+		return series.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, key := range h.keys {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.format(key, "le", formatValue(bound)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.format(key, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.format(key), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.format(key), series.count)
+	}
+}
+
+/* GAUGE */
+// Value read when metrics are scraped, left out of the scrape when it cannot be read
+type GaugeFunc struct {
+	labelled
+	fn func() (float64, error)
+}
+
+func NewGaugeFunc(name string, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{labelled: labelled{metricName: name, help: help}, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	value, err := g.fn()
+	if err != nil {
+		logging.Default().Error("failed to read metric", "metric", g.metricName, "error", err)
+		return
+	}
+	g.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(value))
+}
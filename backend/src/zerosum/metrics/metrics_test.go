@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	counter := NewCounter("test_requests_total", "Requests.", "outcome")
+	counter.Inc("ok")
+	counter.Inc("ok")
+	counter.Add(3, "error")
+	histogram := NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+	NewGaugeFunc("test_money", "Money.", func() (float64, error) { return 42, nil })
+	NewGaugeFunc("test_broken", "Broken.", func() (float64, error) { return 0, errors.New("unavailable") })
+
+	var buf bytes.Buffer
+	Default.Write(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{outcome="ok"} 2`,
+		`test_requests_total{outcome="error"} 3`,
+		`test_duration_seconds_bucket{le="0.1"} 1`,
+		`test_duration_seconds_bucket{le="1"} 2`,
+		`test_duration_seconds_bucket{le="+Inf"} 3`,
+		"test_duration_seconds_sum 5.55",
+		"test_duration_seconds_count 3",
+		"test_money 42",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected line %q in output:\n%s", line, out)
+		}
+	}
+	// Gauges that cannot be read are left out rather than reported as zero
+	if strings.Contains(out, "test_broken") {
+		t.Errorf("Expected broken gauge to be left out, got:\n%s", out)
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	counter := NewCounter("test_labelled_total", "Labelled.", "operation", "outcome")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic for missing label value")
+		}
+	}()
+	counter.Inc("ok")
+}
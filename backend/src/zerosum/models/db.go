@@ -8,6 +8,10 @@ import (
 
 type Stakes string
 type GameMode string
+type GameState string
+type VoidReason string
+type EntryKind string
+type JobStatus string
 
 const (
 	NO_STAKES    Stakes = "NO_STAKES"
@@ -16,29 +20,75 @@ const (
 	NO_LIMIT     Stakes = "NO_LIMIT"
 )
 const (
-	MAJORITY GameMode = "MAJORITY"
-	MINORITY GameMode = "MINORITY"
+	MAJORITY       GameMode = "MAJORITY"
+	MINORITY       GameMode = "MINORITY"
+	BEAUTY_CONTEST GameMode = "BEAUTY_CONTEST"
+	LOWEST_UNIQUE  GameMode = "LOWEST_UNIQUE"
+	SCHELLING      GameMode = "SCHELLING"
+)
+const (
+	ACTIVE   GameState = "ACTIVE"
+	RESOLVED GameState = "RESOLVED"
+	VOIDED   GameState = "VOIDED"
+)
+const (
+	PENDING JobStatus = "PENDING"
+	DONE    JobStatus = "DONE"
+	DEAD    JobStatus = "DEAD" // gave up after too many failed attempts, until an admin retries it
+)
+const (
+	OPENING  EntryKind = "OPENING"  // balance a player had before the ledger was kept
+	GRANT    EntryKind = "GRANT"    // money given to new players
+	STAKE    EntryKind = "STAKE"    // money staked on a game, held in escrow until it is settled
+	PAYOUT   EntryKind = "PAYOUT"   // winnings paid out of a game's escrow
+	REFUND   EntryKind = "REFUND"   // stake given back from a game's escrow
+	PURCHASE EntryKind = "PURCHASE" // money spent on a hat
+	ROUNDING EntryKind = "ROUNDING" // remainder left in a game's escrow after payouts are rounded down
+)
+const (
+	CANCELLED       VoidReason = "CANCELLED"
+	TOO_FEW_PLAYERS VoidReason = "TOO_FEW_PLAYERS"
+	POT_TOO_SMALL   VoidReason = "POT_TOO_SMALL"
 )
 
 type Game struct {
-	Id           string `gorm:"primary_key"`
-	UserId       string // foreign key from user
-	Topic        string
-	StartTime    time.Time
-	EndTime      time.Time
-	Stakes       Stakes
-	GameMode     GameMode
-	Options      []Option `gorm:"foreignkey:GameId"`
-	Participants []User   `gorm:"many2many:votes;"`
-	Resolved     bool
-	Validated    bool
+	Id          string `gorm:"primary_key"`
+	UserId      string // foreign key from user
+	Topic       string
+	StartTime   time.Time
+	EndTime     time.Time
+	Stakes      Stakes
+	FixedAmount int32 // amount every vote must stake in a FIXED_STAKES game
+	MaxAmount   int32 // largest amount a vote may stake in a FIXED_LIMIT game
+	GameMode    GameMode
+	// Numeric games only accept numbers between RangeMin and RangeMax, and are won by the number closest to
+	// TargetFraction of the average
+	RangeMin       float64
+	RangeMax       float64
+	TargetFraction float64
+	Options        []Option `gorm:"foreignkey:GameId"`
+	Participants   []User   `gorm:"many2many:votes;"`
+	// Games that end with fewer players or less money staked than these are voided instead of resolved
+	MinParticipants int32
+	MinPot          int32
+	// Set by the creator to stop players changing or withdrawing their votes
+	LockVotes bool
+	Resolved  bool
+	Validated bool
+	// Voided games end without a result and have every stake refunded
+	Voided     bool
+	VoidReason VoidReason
+	// Computed values after completion, stored to reduce computation
+	TargetValue float64
 }
 
 type Option struct {
 	Id     string `gorm:"primary_key"`
 	Body   string
 	GameId string // foreign key from game
-	Users  []User `gorm:"many2many:votes;"`
+	// Normalised answer grouped under this option, null for options set by the creator
+	AnswerKey string `gorm:"default:null"`
+	Users     []User `gorm:"many2many:votes;"`
 	// Computed values after completion, stored to reduce computation
 	Resolved   bool
 	Winner     bool
@@ -46,6 +96,15 @@ type Option struct {
 	TotalVotes int32
 }
 
+// Result of one number picked in a lowest unique game, stored on completion
+type NumberResult struct {
+	GameId     string `gorm:"primary_key"` // foreign key from game
+	Number     int32  `gorm:"primary_key;auto_increment:false"`
+	Winner     bool
+	TotalValue int32
+	TotalVotes int32
+}
+
 type User struct {
 	Id                   string `gorm:"primary_key"`
 	CreatedAt            time.Time
@@ -60,6 +119,7 @@ type User struct {
 	Experience           int
 	Picture              string
 	PushSubscriptionJson []byte
+	Admin                bool // admins can cancel games created by anyone
 }
 
 type Hat struct {
@@ -78,15 +138,86 @@ type HatOwnership struct {
 }
 
 type Vote struct {
-	GameId   string `gorm:"primary_key"` //foreign key from game
-	UserId   string `gorm:"primary_key"` // foreign key from user
-	OptionId string                      // foreign key from option
+	GameId   string   `gorm:"primary_key"`  //foreign key from game
+	UserId   string   `gorm:"primary_key"`  // foreign key from user
+	OptionId string   `gorm:"default:null"` // foreign key from option, null for numeric games
+	Number   *float64 // numeric games only
+	Answer   string   // free text games only, as typed by the player
 	Money    int32
 	Resolved bool
 	// Computed values after completion, stored to reduce computation
 	Win       bool
 	Change    int32
+	Distance  float64 // numeric games only, distance of Number from the target value
 	Validated bool
+	// Loaded separately, as allocations are keyed by the vote's composite primary key
+	Allocations []Allocation `gorm:"-"`
+}
+
+// Part of a vote's stake placed on one option, a vote may be split across several options of a game
+type Allocation struct {
+	GameId   string `gorm:"primary_key"` // foreign key from vote
+	UserId   string `gorm:"primary_key"` // foreign key from vote
+	OptionId string `gorm:"primary_key"` // foreign key from option
+	Money    int32
+	// Computed values after completion, stored to reduce computation
+	Win    bool
+	Change int32
+}
+
+// Accounts money is moved between in the ledger
+const HOUSE_ACCOUNT = "house"
+
+func UserAccount(userId string) string {
+	return "user:" + userId
+}
+
+func GameAccount(gameId string) string {
+	return "game:" + gameId
+}
+
+// A single movement of money from one account to another. Every change to a player's balance has an entry, so
+// their balance is the sum of the entries paid into their account less the ones taken out of it
+type LedgerEntry struct {
+	Id        string `gorm:"primary_key"`
+	CreatedAt time.Time
+	Kind      EntryKind
+	Debit     string // account the money is taken from
+	Credit    string // account the money is paid into
+	Amount    int32
+	UserId    string `gorm:"default:null"` // foreign key from user, the player whose balance changed
+	GameId    string `gorm:"default:null"` // foreign key from game
+	HatId     string `gorm:"default:null"` // foreign key from hat
+}
+
+// Records a mutation that has already run, so that a retry with the same key replays its result instead of
+// running it again
+type IdempotencyKey struct {
+	UserId    string `gorm:"primary_key"` // foreign key from user
+	Key       string `gorm:"primary_key"`
+	Operation string
+	ResultId  string // id of what the mutation created or changed
+	CreatedAt time.Time
+}
+
+// Resolution of a game, due when the game ends and retried with backoff until it succeeds or runs out of attempts.
+// Any server can run a due job, and holds a lease on it while it does so that no other server runs it at once.
+type ResolutionJob struct {
+	GameId      string `gorm:"primary_key"` // foreign key from game
+	Status      JobStatus
+	Attempts    int32
+	NextAttempt time.Time
+	LastError   string
+	LockedBy    string    // server holding the lease, empty when nobody is running the job
+	LockedUntil time.Time // lease expiry, after which another server may take the job over
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Marks a game as settled, written in the same transaction as the settlement so that it only ever happens once
+type Settlement struct {
+	GameId    string `gorm:"primary_key"` // foreign key from game
+	SettledAt time.Time
 }
 
 func (user *User) BeforeCreate(scope *gorm.Scope) error {
@@ -103,3 +234,8 @@ func (user *Option) BeforeCreate(scope *gorm.Scope) error {
 	scope.SetColumn("Id", ksuid.New().String())
 	return nil
 }
+
+func (entry *LedgerEntry) BeforeCreate(scope *gorm.Scope) error {
+	scope.SetColumn("Id", ksuid.New().String())
+	return nil
+}
@@ -1,13 +1,15 @@
 package push
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/SherClockHolmes/webpush-go"
-	"io/ioutil"
-	"log"
 	"net/http"
+	"zerosum/logging"
+	"zerosum/metrics"
 	"zerosum/models"
 	"zerosum/repository"
+	"zerosum/tracing"
 )
 
 type pushSettings struct {
@@ -29,39 +31,52 @@ func InitPushWithSettings(privKey string, httpClient *http.Client) {
 }
 
 func SubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 	userId := r.Context().Value("Id").(string)
 	sub := webpush.Subscription{}
 	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
-		log.Print(err)
+		logger.Warn("invalid push subscription", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	if err := updateSubscriptionInDb(userId, sub); err != nil {
-		log.Print(err)
+	if err := updateSubscriptionInDb(r.Context(), userId, sub); err != nil {
+		logger.Error("failed to save push subscription", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	} else {
-		log.Printf("user %s has subscribed to push notifications", userId)
+		logger.Info("subscribed to push notifications")
 	}
 }
 
 func UnsubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 	userId := r.Context().Value("Id").(string)
-	if err := updateSubscriptionInDb(userId, emptySubscription); err != nil {
-		log.Print(err)
+	if err := updateSubscriptionInDb(r.Context(), userId, emptySubscription); err != nil {
+		logger.Error("failed to remove push subscription", "error", err)
 		http.Error(w, err.Error(), 500)
 		return
 	} else {
-		log.Printf("user %s has unsubscribed from push notifications", userId)
+		logger.Info("unsubscribed from push notifications")
 	}
 }
 
-func SendNotif(body string, userId string) (error) {
-	s, err := getSubscriptionFromDb(userId)
+var notificationsTotal = metrics.NewCounter("zerosum_push_notifications_total",
+	"Push notifications by result (sent, failed or unsubscribed).", "result")
+
+func SendNotif(ctx context.Context, body string, userId string) (err error) {
+	span, ctx := tracing.StartSpan(ctx, "push.SendNotif")
+	span.SetTag("user.id", userId)
+	defer func() {
+		tracing.FinishSpan(span, err)
+	}()
+
+	s, err := getSubscriptionFromDb(ctx, userId)
 	if err != nil {
+		notificationsTotal.Inc("unsubscribed")
 		return err // Likely new user/denied permissions
 	}
 	if s.Endpoint == "" || s.Endpoint == "nil" {
+		notificationsTotal.Inc("unsubscribed")
 		return nil // Unsubscribed user
 	}
 	resp, err := webpush.SendNotification([]byte(body), &s, &webpush.Options{
@@ -71,27 +86,29 @@ func SendNotif(body string, userId string) (error) {
 		Urgency:         webpush.UrgencyNormal,
 	})
 	if err != nil {
+		notificationsTotal.Inc("failed")
 		return err
 	}
-	log.Printf("%s, %d", resp.Status, resp.StatusCode)
-	bodyString, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Print(err)
+	defer resp.Body.Close()
+	logger := logging.FromContext(ctx).With("userId", userId, "status", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		notificationsTotal.Inc("failed")
+		logger.Warn("push notification rejected")
 	} else {
-		log.Printf(string(bodyString))
+		notificationsTotal.Inc("sent")
+		logger.Debug("push notification sent")
 	}
-	log.Printf("notified %s via push", userId)
 	return nil
 }
-func getSubscriptionFromDb(userId string) (webpush.Subscription, error) {
-	user, err := repository.QueryUser(models.User{Id: userId})
+func getSubscriptionFromDb(ctx context.Context, userId string) (webpush.Subscription, error) {
+	user, err := repository.QueryUser(ctx, models.User{Id: userId})
 	if err != nil {
 		return emptySubscription, err
 	}
 	return GetSubscriptionFromJson(user.PushSubscriptionJson)
 }
-func updateSubscriptionInDb(userId string, sub webpush.Subscription) error {
-	user, err := repository.QueryUser(models.User{Id: userId})
+func updateSubscriptionInDb(ctx context.Context, userId string, sub webpush.Subscription) error {
+	user, err := repository.QueryUser(ctx, models.User{Id: userId})
 	if err != nil {
 		return err
 	}
@@ -1,50 +1,140 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"time"
+	"zerosum/apperrors"
 	"zerosum/models"
 )
 
 // Global singleton instance
 var db *gorm.DB
 
+// Money every new player starts with
+const STARTING_MONEY = 2000
+
 /* DATABASE CONFIG*/
 func InitTestDB() (err error) {
+	return InitDB("zerosum")
+}
+
+// Connects to and migrates the given database on the local postgres server
+func InitDB(dbName string) (err error) {
 	db, err = gorm.Open("postgres", fmt.Sprintf(
 		"user=%s password=%s dbname=%s host=%s port=%d sslmode=disable",
-		"postgres", "password", "zerosum", "localhost", 5432))
+		"postgres", "password", dbName, "localhost", 5432))
+	if err != nil {
+		return
+	}
+	registerTracingCallbacks(db)
 
 	// Set up database tables
-	db.AutoMigrate(&models.Game{}, &models.Option{}, &models.User{}, &models.Hat{}, &models.Vote{}, &models.HatOwnership{})
+	db.AutoMigrate(&models.Game{}, &models.Option{}, &models.User{}, &models.Hat{}, &models.Vote{}, &models.HatOwnership{},
+		&models.Settlement{}, &models.NumberResult{}, &models.Allocation{}, &models.LedgerEntry{},
+		&models.IdempotencyKey{}, &models.ResolutionJob{})
 
 	// Add foreign key constraints
 	db.Model(models.Game{}).AddForeignKey("user_id", "users(id)", "CASCADE", "RESTRICT")
 	db.Model(models.Option{}).AddForeignKey("game_id", "games(id)", "CASCADE", "RESTRICT")
+	db.Model(models.Option{}).AddUniqueIndex("idx_option_game_answer", "game_id", "answer_key")
 	db.Model(models.Vote{}).AddForeignKey("option_id", "options(id)", "CASCADE", "RESTRICT")
 	db.Model(models.Vote{}).AddForeignKey("user_id", "users(id)", "CASCADE", "RESTRICT")
 	db.Model(models.Vote{}).AddForeignKey("game_id", "games(id)", "CASCADE", "RESTRICT")
 	db.Model(models.HatOwnership{}).AddForeignKey("user_id", "users(id)", "CASCADE", "RESTRICT")
 	db.Model(models.HatOwnership{}).AddForeignKey("hat_id", "hats(id)", "CASCADE", "RESTRICT")
+	db.Model(models.Settlement{}).AddForeignKey("game_id", "games(id)", "CASCADE", "RESTRICT")
+	db.Model(models.NumberResult{}).AddForeignKey("game_id", "games(id)", "CASCADE", "RESTRICT")
+	db.Model(models.Allocation{}).AddForeignKey("game_id, user_id", "votes(game_id, user_id)", "CASCADE", "RESTRICT")
+	db.Model(models.Allocation{}).AddForeignKey("option_id", "options(id)", "CASCADE", "RESTRICT")
+	db.Model(models.LedgerEntry{}).AddForeignKey("user_id", "users(id)", "CASCADE", "RESTRICT")
+	db.Model(models.LedgerEntry{}).AddForeignKey("game_id", "games(id)", "CASCADE", "RESTRICT")
+	db.Model(models.LedgerEntry{}).AddForeignKey("hat_id", "hats(id)", "CASCADE", "RESTRICT")
+	db.Model(models.LedgerEntry{}).AddIndex("idx_ledger_entry_user", "user_id")
+	db.Model(models.IdempotencyKey{}).AddForeignKey("user_id", "users(id)", "CASCADE", "RESTRICT")
+	db.Model(models.ResolutionJob{}).AddForeignKey("game_id", "games(id)", "CASCADE", "RESTRICT")
+	db.Model(models.ResolutionJob{}).AddIndex("idx_resolution_job_due", "status", "next_attempt")
+	err = addSearchIndexes()
+
+	// Players who joined before the ledger was kept start it with their balance at the time
+	if err == nil {
+		err = backfillOpeningBalances()
+	}
+	// Games created before resolution jobs were kept are queued to be resolved when they end
+	if err == nil {
+		err = backfillResolutionJobs()
+	}
 	return
 }
 
+func backfillResolutionJobs() (err error) {
+	var games []models.Game
+	res := db.Where("NOT resolved AND NOT voided AND " +
+		"NOT EXISTS (SELECT 1 FROM resolution_jobs WHERE resolution_jobs.game_id = games.id)").Find(&games)
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	for _, game := range games {
+		err = enqueueResolutionJob(db, game.Id, game.EndTime)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func backfillOpeningBalances() (err error) {
+	var users []models.User
+	res := db.Where("NOT EXISTS (SELECT 1 FROM ledger_entries WHERE ledger_entries.user_id = users.id)").Find(&users)
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	for _, user := range users {
+		err = createLedgerEntry(db, models.LedgerEntry{
+			Kind:   models.OPENING,
+			Debit:  models.HOUSE_ACCOUNT,
+			Credit: models.UserAccount(user.Id),
+			Amount: user.MoneyTotal,
+			UserId: user.Id,
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns an error unless the DB answers before the context is done
+func Ping(ctx context.Context) error {
+	return db.DB().PingContext(ctx)
+}
+
+func CloseDB() error {
+	return db.Close()
+}
+
 func CloseTestDB() {
 	db.Close()
 }
 
 /* POLL CRUD */
 func CreateGame(game models.Game) (createdGame models.Game, err error) {
+	return createGame(db, game)
+}
+
+func createGame(conn *gorm.DB, game models.Game) (createdGame models.Game, err error) {
 	// Check if alr exists
-	if !db.NewRecord(game) {
+	if !conn.NewRecord(game) {
 		err = errors.New("game exists")
 		return
 	}
 
-	res := db.Create(&game)
+	res := conn.Create(&game)
 	if res.Error != nil {
 		err = res.Error
 	}
@@ -52,10 +142,14 @@ func CreateGame(game models.Game) (createdGame models.Game, err error) {
 	return
 }
 
-func QueryGame(desiredGame models.Game) (game models.Game, err error) {
-	res := db.Where(desiredGame).First(&game)
+func QueryGame(ctx context.Context, desiredGame models.Game) (game models.Game, err error) {
+	return queryGame(withContext(ctx), desiredGame)
+}
+
+func queryGame(conn *gorm.DB, desiredGame models.Game) (game models.Game, err error) {
+	res := conn.Where(desiredGame).First(&game)
 	if res.RecordNotFound() {
-		err = errors.New("no game found")
+		err = apperrors.NotFound("no game found")
 	} else if res.Error != nil {
 		err = res.Error
 	}
@@ -63,130 +157,85 @@ func QueryGame(desiredGame models.Game) (game models.Game, err error) {
 	return
 }
 
-func SearchUnresolvedGames() (games []models.Game) {
-	db.Where("resolved = ?", false).Find(&games)
+// Games with any of the given ids, in no particular order
+func QueryGamesByIds(ctx context.Context, ids []string) (games []models.Game, err error) {
+	err = withContext(ctx).Where("id IN (?)", ids).Find(&games).Error
 	return
 }
 
-func SearchActiveGames(searchString string, joined *bool, created *bool, userId string, limit *int32) (games []models.Game, err error) {
+func SearchUnresolvedGames() (games []models.Game) {
+	db.Where("resolved = ? AND voided = ?", false, false).Find(&games)
+	return
+}
 
+func SearchActiveGames(ctx context.Context, searchString string, joined *bool, created *bool, userId string, after *GameKey, limit int) (games []models.Game, err error) {
 	if joined == nil && created == nil {
-		err = errors.New("created and joined are both not specified")
+		err = apperrors.Validation("created and joined are both not specified")
+		return
 	}
 
 	// Get games that fit the search query
-	interm := db.Order("end_time asc").Where("topic LIKE ? AND end_time > ?", fmt.Sprintf("%%%s%%", searchString), time.Now())
-	var candidateActiveGames []models.Game
-
-	// Get games according to created flag, or all valid games if nil
-	if created != nil {
-		switch *created {
-		case true:
-			res := interm.Where("user_id = ?", userId).Find(&candidateActiveGames)
-			if res.Error != nil {
-				err = res.Error
-			}
-		case false:
-			res := interm.Where("user_id <> ?", userId).Find(&candidateActiveGames)
-			if res.Error != nil {
-				err = res.Error
-			}
-		}
-	} else {
-		res := interm.Find(&candidateActiveGames)
-		if res.Error != nil {
-			err = res.Error
-		}
-	}
-
+	query, err := searchQuery(withContext(ctx), searchString)
 	if err != nil {
 		return
 	}
-
-	// Filter to limit and joined
-	var activeGames []models.Game
-
-	gamesLeft := int32(-1)
-	if limit != nil {
-		gamesLeft = *limit
-	}
-
-	for _, game := range candidateActiveGames {
-		if gamesLeft == 0 {
-			break
-		}
-		//  Filter by joined if needed, else just add till limit
-		if joined != nil {
-			_, internalErr, recordNotFound := QueryVote(models.Vote{UserId: userId, GameId: game.Id})
-			if internalErr != nil && !recordNotFound {
-				err = internalErr
-				return
-			}
-			if !recordNotFound && *joined {
-				activeGames = append(activeGames, game)
-				gamesLeft -= 1
-			} else if recordNotFound && !*joined {
-				activeGames = append(activeGames, game)
-				gamesLeft -= 1
-			}
+	interm := matchText(withContext(ctx).Where("end_time > ? AND voided = ?", time.Now(), false), query)
+	if created != nil {
+		if *created {
+			interm = interm.Where("user_id = ?", userId)
 		} else {
-			activeGames = append(activeGames, game)
-			gamesLeft -= 1
+			interm = interm.Where("user_id <> ?", userId)
 		}
 	}
-	games = activeGames
+	if joined != nil {
+		joinedQuery := "EXISTS (SELECT 1 FROM votes WHERE votes.game_id = games.id AND votes.user_id = ?)"
+		if !*joined {
+			joinedQuery = "NOT " + joinedQuery
+		}
+		interm = interm.Where(joinedQuery, userId)
+	}
+	err = gamesAfter(interm, after, limit).Find(&games).Error
 	return
 }
 
-func GetCompletedGames(userId string, created bool) (games []models.Game, err error) {
-
-	interm := db.Order("end_time asc")
-	// Get games that are completed and fit the created requirement
+func GetCompletedGames(ctx context.Context, userId string, created bool, after *GameKey,
+	limit int) (games []models.Game, err error) {
+	// Get games that are completed, either resolved or voided, and whose result the user has not validated yet
+	interm := withContext(ctx).Where("resolved = ? OR voided = ?", true, true)
 	if created {
-		interm = interm.Where("user_id = ? AND validated = ? AND resolved = ?", userId, false, true)
+		interm = interm.Where("user_id = ? AND validated = ?", userId, false)
 	} else {
-		interm = interm.Where("user_id <> ? AND resolved = ?", userId, true)
-	}
-	var candidateCompletedGames []models.Game
-	res := interm.Find(&candidateCompletedGames)
-	if res.Error != nil {
-		err = res.Error
+		interm = interm.Where("user_id <> ? AND EXISTS (SELECT 1 FROM votes WHERE votes.game_id = games.id AND "+
+			"votes.user_id = ? AND votes.validated = ?)", userId, userId, false)
 	}
+	err = gamesAfter(interm, after, limit).Find(&games).Error
+	return
+}
 
-	// If games were not created by user, ensure that they are joined by user and not validated
-	if created {
-		games = candidateCompletedGames
-		return
-	} else {
-		var validGames []models.Game
-		for _, game := range candidateCompletedGames {
-			vote, internalErr, recordNotFound := QueryVote(models.Vote{UserId: userId, GameId: game.Id})
-			if internalErr != nil && !recordNotFound {
-				err = internalErr
-				return
-			}
-			// Result not validated by user yet
-			if !recordNotFound && !vote.Validated {
-				validGames = append(validGames, game)
-			}
-		}
-		games = validGames
-		return
+// Orders games by end time and skips those up to the given one
+func gamesAfter(interm *gorm.DB, after *GameKey, limit int) *gorm.DB {
+	if after != nil {
+		interm = interm.Where("(games.end_time, games.id) > (?, ?)", after.EndTime, after.Id)
 	}
+	return interm.Order("games.end_time asc, games.id asc").Limit(limit)
 }
 
-func CountGames() (total int32) {
-	db.Model(&models.Game{}).Where("end_time > ?", time.Now()).Count(&total)
+func CountGames(ctx context.Context) (total int32) {
+	withContext(ctx).Model(&models.Game{}).Where("end_time > ?", time.Now()).Count(&total)
 	return
 }
 
 func UpdateGame(game models.Game) (err error) {
+	return updateGame(db, game)
+}
+
+func updateGame(conn *gorm.DB, game models.Game) (err error) {
 	// Check if exists
-	if db.NewRecord(game) {
-		err = errors.New("game does not exist")
+	if conn.NewRecord(game) {
+		err = apperrors.NotFound("game does not exist")
 		return
 	}
-	res := db.Model(&models.Game{}).Updates(game)
+	res := conn.Model(&models.Game{}).Updates(game)
 	if res.Error != nil {
 		err = res.Error
 	}
@@ -197,7 +246,7 @@ func UpdateGame(game models.Game) (err error) {
 func DeleteGame(game models.Game) (err error) {
 	// Check if exists
 	if db.NewRecord(game) {
-		err = errors.New("game does not exist")
+		err = apperrors.NotFound("game does not exist")
 		return
 	}
 	res := db.Delete(&game)
@@ -208,15 +257,23 @@ func DeleteGame(game models.Game) (err error) {
 	return
 }
 
-func QueryGameOptions(desiredGame models.Game) (options []models.Option, err error) {
-	err = db.Model(&desiredGame).Association("Options").Find(&options).Error
+func QueryGameOptions(ctx context.Context, desiredGame models.Game) (options []models.Option, err error) {
+	return queryGameOptions(withContext(ctx), desiredGame)
+}
+
+func queryGameOptions(conn *gorm.DB, desiredGame models.Game) (options []models.Option, err error) {
+	err = conn.Model(&desiredGame).Association("Options").Find(&options).Error
 	return
 }
 
 func QueryOption(desiredOption models.Option) (option models.Option, err error) {
-	res := db.Where(desiredOption).First(&option)
+	return queryOption(db, desiredOption)
+}
+
+func queryOption(conn *gorm.DB, desiredOption models.Option) (option models.Option, err error) {
+	res := conn.Where(desiredOption).First(&option)
 	if res.RecordNotFound() {
-		err = errors.New("no option found")
+		err = apperrors.NotFound("no option found")
 	} else if res.Error != nil {
 		err = res.Error
 	}
@@ -224,13 +281,37 @@ func QueryOption(desiredOption models.Option) (option models.Option, err error)
 	return
 }
 
+func QueryOptionsByIds(ctx context.Context, ids []string) (options []models.Option, err error) {
+	err = withContext(ctx).Where("id IN (?)", ids).Find(&options).Error
+	return
+}
+
+// Options of all the given games at once
+func QueryOptionsOfGames(ctx context.Context, gameIds []string) (options []models.Option, err error) {
+	err = withContext(ctx).Where("game_id IN (?)", gameIds).Find(&options).Error
+	return
+}
+
+// Gets the option grouping a free text answer, creating it from the answer if no one has given it yet. Callers hold
+// the lock on the game, so no other player can create the same answer at once.
+func getOrCreateAnswerOption(conn *gorm.DB, gameId string, body string,
+	answerKey string) (option models.Option, err error) {
+	desiredOption := models.Option{GameId: gameId, AnswerKey: answerKey}
+	err = conn.Where(desiredOption).Attrs(models.Option{Body: body}).FirstOrCreate(&option).Error
+	return
+}
+
 func UpdateOption(option models.Option) (err error) {
+	return updateOption(db, option)
+}
+
+func updateOption(conn *gorm.DB, option models.Option) (err error) {
 	// Check if exists
-	if db.NewRecord(option) {
-		err = errors.New("option does not exist")
+	if conn.NewRecord(option) {
+		err = apperrors.NotFound("option does not exist")
 		return
 	}
-	res := db.Model(&models.Option{}).Updates(option)
+	res := conn.Model(&models.Option{}).Updates(option)
 	if res.Error != nil {
 		err = res.Error
 	}
@@ -238,26 +319,65 @@ func UpdateOption(option models.Option) (err error) {
 	return
 }
 
+// Number results of all the given games at once, from the lowest number
+func QueryNumberResultsOfGames(ctx context.Context, gameIds []string) (numberResults []models.NumberResult, err error) {
+	err = withContext(ctx).Where("game_id IN (?)", gameIds).Order("number asc").Find(&numberResults).Error
+	return
+}
+
+func createNumberResult(conn *gorm.DB, numberResult models.NumberResult) (err error) {
+	res := conn.Create(&numberResult)
+	if res.Error != nil {
+		err = res.Error
+	}
+	return
+}
+
 /* USER CRUD */
 func GetOrCreateUser(desiredUser models.User) (user models.User, err error) {
-	// Check if alr exists
-	err = db.Where("fb_id = ?", desiredUser.FbId).Attrs(models.User{
-		FbId:                 desiredUser.FbId,
-		MoneyTotal:           int32(2000),
-		WinRate:              0,
-		GamesPlayed:          0,
-		GamesWon:             0,
-		Experience:           0,
-		Name:                 "HatMatter",
-		PushSubscriptionJson: nil,
-	}).FirstOrCreate(&user).Error
+	err = Transaction(context.Background(), func(tx *Tx) (err error) {
+		// Check if alr exists
+		res := tx.conn.Where("fb_id = ?", desiredUser.FbId).First(&user)
+		if !res.RecordNotFound() {
+			err = res.Error
+			return
+		}
+
+		user = models.User{
+			FbId:                 desiredUser.FbId,
+			MoneyTotal:           STARTING_MONEY,
+			WinRate:              0,
+			GamesPlayed:          0,
+			GamesWon:             0,
+			Experience:           0,
+			Name:                 "HatMatter",
+			PushSubscriptionJson: nil,
+		}
+		err = tx.conn.Create(&user).Error
+		if err != nil {
+			return
+		}
+		// New players' money is granted through the ledger like any other movement
+		err = createLedgerEntry(tx.conn, models.LedgerEntry{
+			Kind:   models.GRANT,
+			Debit:  models.HOUSE_ACCOUNT,
+			Credit: models.UserAccount(user.Id),
+			Amount: user.MoneyTotal,
+			UserId: user.Id,
+		})
+		return
+	})
 	return
 }
 
-func QueryUser(desiredUser models.User) (user models.User, err error) {
-	res := db.Where(desiredUser).First(&user)
+func QueryUser(ctx context.Context, desiredUser models.User) (user models.User, err error) {
+	return queryUser(withContext(ctx), desiredUser)
+}
+
+func queryUser(conn *gorm.DB, desiredUser models.User) (user models.User, err error) {
+	res := conn.Where(desiredUser).First(&user)
 	if res.RecordNotFound() {
-		err = errors.New("no user found")
+		err = apperrors.NotFound("no user found")
 	} else if res.Error != nil {
 		err = res.Error
 	}
@@ -265,39 +385,102 @@ func QueryUser(desiredUser models.User) (user models.User, err error) {
 	return
 }
 
+func QueryUsersByIds(ctx context.Context, ids []string) (users []models.User, err error) {
+	err = withContext(ctx).Where("id IN (?)", ids).Find(&users).Error
+	return
+}
+
 func QueryAllUsers() (users []models.User) {
 	db.Find(&users)
 	return
 }
 
-func QueryTopUsers(limit int, minGames int) (users []models.User, err error) {
-	err = db.Where("games_played > ?", minGames).Order("win_rate desc").Limit(limit).Find(&users).Error
+// Users on the leaderboard, from the highest win rate
+func QueryTopUsers(ctx context.Context, minGames int, after *UserKey, limit int) (users []models.User, err error) {
+	interm := withContext(ctx).Where("games_played > ?", minGames)
+	if after != nil {
+		interm = interm.Where("win_rate < ? OR (win_rate = ? AND id > ?)", after.WinRate, after.WinRate, after.Id)
+	}
+	err = interm.Order("win_rate desc, id asc").Limit(limit).Find(&users).Error
 	return
 }
 
-func QueryRankedUsers(minGames int) (users []models.User, err error) {
-	err = db.Where("games_played > ?", minGames).Order("win_rate desc").Find(&users).Error
+func QueryRankedUsers(ctx context.Context, minGames int) (users []models.User, err error) {
+	err = withContext(ctx).Where("games_played > ?", minGames).Order("win_rate desc, id asc").Find(&users).Error
 	return
 }
 
 func UpdateUser(user models.User) (err error) {
+	return updateUser(db, user)
+}
+
+func updateUser(conn *gorm.DB, user models.User) (err error) {
 	// Check if exists
-	if db.NewRecord(user) {
-		err = errors.New("user does not exist")
+	if conn.NewRecord(user) {
+		err = apperrors.NotFound("user does not exist")
+		return
+	}
+	// Balance, exp and stats are only changed in place, so that a stale copy of the user cannot overwrite them
+	res := conn.Model(&models.User{}).Omit("money_total", "experience", "games_played", "games_won", "win_rate").
+		Updates(user)
+	if res.Error != nil {
+		err = res.Error
+	}
+
+	return
+}
+
+// Adds money to a user's balance in a single statement, failing instead of letting the balance go below 0
+func adjustUserMoney(conn *gorm.DB, userId string, money int32) (err error) {
+	res := conn.Model(&models.User{}).Where("id = ? AND money_total + ? >= 0", userId, money).
+		UpdateColumn("money_total", gorm.Expr("money_total + ?", money))
+	if res.Error != nil {
+		err = res.Error
 		return
 	}
-	res := db.Model(&models.User{}).Updates(user)
+	if res.RowsAffected == 0 {
+		_, err = queryUser(conn, models.User{Id: userId})
+		if err == nil {
+			err = apperrors.New(apperrors.INSUFFICIENT_FUNDS, "not enough money").With("amount", -money)
+		}
+	}
+	return
+}
+
+func addUserExperience(conn *gorm.DB, userId string, exp int) (err error) {
+	res := conn.Model(&models.User{}).Where("id = ?", userId).
+		UpdateColumn("experience", gorm.Expr("experience + ?", exp))
 	if res.Error != nil {
 		err = res.Error
+	} else if res.RowsAffected == 0 {
+		err = apperrors.NotFound("no user found")
 	}
+	return
+}
 
+// Counts a finished game towards a user's stats
+func recordUserGame(conn *gorm.DB, userId string, win bool) (err error) {
+	won := 0
+	if win {
+		won = 1
+	}
+	res := conn.Model(&models.User{}).Where("id = ?", userId).UpdateColumns(map[string]interface{}{
+		"games_played": gorm.Expr("games_played + 1"),
+		"games_won":    gorm.Expr("games_won + ?", won),
+		"win_rate":     gorm.Expr("CAST(games_won + ? AS DOUBLE PRECISION) / (games_played + 1)", won),
+	})
+	if res.Error != nil {
+		err = res.Error
+	} else if res.RowsAffected == 0 {
+		err = apperrors.NotFound("no user found")
+	}
 	return
 }
 
 func DeleteUser(user models.User) (err error) {
 	// Check if exists
 	if db.NewRecord(user) {
-		err = errors.New("user does not exist")
+		err = apperrors.NotFound("user does not exist")
 		return
 	}
 	res := db.Delete(&user)
@@ -310,24 +493,76 @@ func DeleteUser(user models.User) (err error) {
 
 /* VOTE CRUD */
 func CreateVote(vote models.Vote) (err error) {
+	// Vote and its allocations are created together
+	return Transaction(context.Background(), func(tx *Tx) error {
+		return createVote(tx.conn, vote)
+	})
+}
+
+func createVote(conn *gorm.DB, vote models.Vote) (err error) {
 	// Check if alr exists
 	var foundVote models.Vote
-	if !db.Where("user_id = ? AND game_id = ?", vote.UserId, vote.GameId).First(&foundVote).RecordNotFound() {
-		err = errors.New("vote exists")
+	if !conn.Where("user_id = ? AND game_id = ?", vote.UserId, vote.GameId).First(&foundVote).RecordNotFound() {
+		err = apperrors.New(apperrors.ALREADY_VOTED, "vote exists")
 		return
 	}
-	res := db.Create(&vote)
+	res := conn.Create(&vote)
 	if res.Error != nil {
 		err = res.Error
+		return
 	}
+	err = createAllocations(conn, vote)
+	return
+}
 
+func createAllocations(conn *gorm.DB, vote models.Vote) (err error) {
+	for _, allocation := range vote.Allocations {
+		allocation.GameId = vote.GameId
+		allocation.UserId = vote.UserId
+		res := conn.Create(&allocation)
+		if res.Error != nil {
+			err = res.Error
+			return
+		}
+	}
 	return
 }
 
-func QueryVote(desiredVote models.Vote) (vote models.Vote, err error, recordNotFound bool) {
-	res := db.Where(desiredVote).First(&vote)
+// Replaces the choice and stake of an existing vote, along with all of its allocations
+func replaceVote(conn *gorm.DB, vote models.Vote) (err error) {
+	// Updated through a map, as the new choice may clear columns set by the old one
+	var optionId interface{}
+	if vote.OptionId != "" {
+		optionId = vote.OptionId
+	}
+	res := conn.Model(&models.Vote{}).
+		Where("game_id = ? AND user_id = ?", vote.GameId, vote.UserId).
+		Updates(map[string]interface{}{"option_id": optionId, "number": vote.Number, "answer": vote.Answer, "money": vote.Money})
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	if res.RowsAffected == 0 {
+		err = apperrors.NotFound("vote does not exist")
+		return
+	}
+	res = conn.Where("game_id = ? AND user_id = ?", vote.GameId, vote.UserId).Delete(models.Allocation{})
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	err = createAllocations(conn, vote)
+	return
+}
+
+func QueryVote(ctx context.Context, desiredVote models.Vote) (vote models.Vote, err error, recordNotFound bool) {
+	return queryVote(withContext(ctx), desiredVote)
+}
+
+func queryVote(conn *gorm.DB, desiredVote models.Vote) (vote models.Vote, err error, recordNotFound bool) {
+	res := conn.Where(desiredVote).First(&vote)
 	if res.RecordNotFound() {
-		err = errors.New("no vote found")
+		err = apperrors.NotFound("no vote found")
 		recordNotFound = true
 	} else if res.Error != nil {
 		err = res.Error
@@ -336,42 +571,80 @@ func QueryVote(desiredVote models.Vote) (vote models.Vote, err error, recordNotF
 	return
 }
 
-func QueryVotes(desiredVote models.Vote, limit *int32, after *int32) (votes []models.Vote, err error) {
-
-	offset := *after
-	if after == nil {
-		offset = 0
+// A player's votes, from the game that ended last, along with the key of each
+func QueryUserVotes(ctx context.Context, userId string, after *VoteKey,
+	limit int) (votes []models.Vote, keys []VoteKey, err error) {
+	interm := withContext(ctx).Table("votes").Select("votes.*, games.end_time AS game_end_time").
+		Joins("JOIN games ON games.id = votes.game_id").Where("votes.user_id = ?", userId)
+	if after != nil {
+		interm = interm.Where("(games.end_time, votes.game_id) < (?, ?)", after.EndTime, after.GameId)
 	}
-
-	interm := db.Offset(offset)
-	if limit != nil {
-		interm = interm.Limit(limit)
+	var rows []struct {
+		models.Vote
+		GameEndTime time.Time
 	}
-
-	res := interm.Where(desiredVote).Find(&votes)
-	if res.Error != nil {
-		err = res.Error
+	err = interm.Order("games.end_time desc, votes.game_id desc").Limit(limit).Scan(&rows).Error
+	for _, row := range rows {
+		votes = append(votes, row.Vote)
+		keys = append(keys, VoteKey{EndTime: row.GameEndTime, GameId: row.GameId})
 	}
 	return
 }
 
-func QueryAllGameVotes(desiredGame models.Game) (votes []models.Vote, err error) {
-	err = db.Where("game_id = ?", desiredGame.Id).Find(&votes).Error
+// Votes of all the given games at once
+func QueryVotesOfGames(ctx context.Context, gameIds []string) (votes []models.Vote, err error) {
+	err = withContext(ctx).Where("game_id IN (?)", gameIds).Find(&votes).Error
+	return
+}
+
+func queryAllGameVotes(conn *gorm.DB, desiredGame models.Game) (votes []models.Vote, err error) {
+	err = conn.Where("game_id = ?", desiredGame.Id).Find(&votes).Error
 	return
 }
 
 func QueryOptionVotes(desiredOption models.Option) (votes []models.Vote, err error) {
-	err = db.Where("option_id = ?", desiredOption.Id).Find(&votes).Error
+	return queryOptionVotes(db, desiredOption)
+}
+
+func queryOptionVotes(conn *gorm.DB, desiredOption models.Option) (votes []models.Vote, err error) {
+	err = conn.Where("option_id = ?", desiredOption.Id).Find(&votes).Error
+	return
+}
+
+// Allocations of the votes any of the users placed in any of the games
+func QueryAllocationsOfVotes(ctx context.Context, gameIds []string,
+	userIds []string) (allocations []models.Allocation, err error) {
+	err = withContext(ctx).Where("game_id IN (?) AND user_id IN (?)", gameIds, userIds).Find(&allocations).Error
+	return
+}
+
+func queryGameAllocations(conn *gorm.DB, desiredGame models.Game) (allocations []models.Allocation, err error) {
+	err = conn.Where("game_id = ?", desiredGame.Id).Find(&allocations).Error
+	return
+}
+
+func updateAllocationResult(conn *gorm.DB, allocation models.Allocation) (err error) {
+	// Updated through a map, as a losing allocation's result is made of zero values
+	res := conn.Model(&models.Allocation{}).
+		Where("game_id = ? AND user_id = ? AND option_id = ?", allocation.GameId, allocation.UserId, allocation.OptionId).
+		Updates(map[string]interface{}{"win": allocation.Win, "change": allocation.Change})
+	if res.Error != nil {
+		err = res.Error
+	}
 	return
 }
 
 func UpdateVote(vote models.Vote) (err error) {
+	return updateVote(db, vote)
+}
+
+func updateVote(conn *gorm.DB, vote models.Vote) (err error) {
 	// Check if exists
-	if db.NewRecord(vote) {
-		err = errors.New("vote does not exist")
+	if conn.NewRecord(vote) {
+		err = apperrors.NotFound("vote does not exist")
 		return
 	}
-	res := db.Model(&models.Vote{}).Updates(vote)
+	res := conn.Model(&models.Vote{}).Updates(vote)
 	if res.Error != nil {
 		err = res.Error
 	}
@@ -380,12 +653,17 @@ func UpdateVote(vote models.Vote) (err error) {
 }
 
 func DeleteVote(vote models.Vote) (err error) {
+	return deleteVote(db, vote)
+}
+
+func deleteVote(conn *gorm.DB, vote models.Vote) (err error) {
 	// Check if exists
-	if db.NewRecord(vote) {
-		err = errors.New("vote does not exist")
+	if conn.NewRecord(vote) {
+		err = apperrors.NotFound("vote does not exist")
 		return
 	}
-	res := db.Delete(&vote)
+	// Allocations of the vote are deleted along with it by their foreign key
+	res := conn.Delete(&vote)
 	if res.Error != nil {
 		err = res.Error
 	}
@@ -398,6 +676,210 @@ func CheckVoted(userId string, gameId string) bool {
 	return !db.Where("user_id = ? AND game_id = ?", userId, gameId).First(&vote).RecordNotFound()
 }
 
+// Which of the given games the user has voted in
+func QueryVotedGameIds(ctx context.Context, userId string, gameIds []string) (votedIds []string, err error) {
+	err = withContext(ctx).Model(&models.Vote{}).Where("user_id = ? AND game_id IN (?)", userId, gameIds).
+		Pluck("game_id", &votedIds).Error
+	return
+}
+
+// Money staked in each of the given games, leaving out games without votes
+func SumGameVotes(ctx context.Context, gameIds []string) (sums map[string]int32, err error) {
+	rows, err := withContext(ctx).Model(&models.Vote{}).Select("game_id, sum(money)").
+		Where("game_id IN (?)", gameIds).Group("game_id").Rows()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	sums = make(map[string]int32)
+	for rows.Next() {
+		var gameId string
+		var sum int32
+		if err = rows.Scan(&gameId, &sum); err != nil {
+			return
+		}
+		sums[gameId] = sum
+	}
+	err = rows.Err()
+	return
+}
+
+/* LEDGER CRUD */
+func createLedgerEntry(conn *gorm.DB, entry models.LedgerEntry) (err error) {
+	res := conn.Create(&entry)
+	if res.Error != nil {
+		err = res.Error
+	}
+	return
+}
+
+// Entries that changed a player's balance, newest first
+func QueryUserLedgerEntries(ctx context.Context, userId string, after *LedgerKey,
+	limit int) (entries []models.LedgerEntry, err error) {
+	interm := withContext(ctx).Where("user_id = ?", userId)
+	if after != nil {
+		interm = interm.Where("(created_at, id) < (?, ?)", after.CreatedAt, after.Id)
+	}
+	res := interm.Order("created_at desc, id desc").Limit(limit).Find(&entries)
+	if res.Error != nil {
+		err = res.Error
+	}
+	return
+}
+
+// Balance of an account as derived from the ledger, money paid into it less money taken out of it
+func QueryAccountBalance(account string) (balance int32, err error) {
+	row := db.Raw("SELECT COALESCE(SUM(CASE WHEN credit = ? THEN amount ELSE -amount END), 0) FROM ledger_entries "+
+		"WHERE credit = ? OR debit = ?", account, account, account).Row()
+	err = row.Scan(&balance)
+	return
+}
+
+/* RESOLUTION_JOB CRUD */
+// Queues a game to be resolved once it is due, leaving any job it already has untouched
+func enqueueResolutionJob(conn *gorm.DB, gameId string, due time.Time) (err error) {
+	now := time.Now()
+	res := exec(conn, "INSERT resolution_jobs", "INSERT INTO resolution_jobs (game_id, status, attempts, next_attempt, last_error, locked_by, "+
+		"locked_until, created_at, updated_at) VALUES (?, ?, 0, ?, '', '', ?, ?, ?) ON CONFLICT DO NOTHING",
+		gameId, models.PENDING, due, time.Time{}, now, now)
+	if res.Error != nil {
+		err = res.Error
+	}
+	return
+}
+
+// Takes a lease on due jobs that nobody else holds, skipping rows another server is claiming at the same time, so
+// that every job is only ever run by one server at once
+func claimResolutionJobs(filter string, owner string, now time.Time, lease time.Duration, limit int,
+	args ...interface{}) (jobs []models.ResolutionJob, err error) {
+	query := "UPDATE resolution_jobs SET locked_by = ?, locked_until = ? WHERE game_id IN (" +
+		"SELECT game_id FROM resolution_jobs WHERE status = ? AND next_attempt <= ? AND locked_until <= ?" + filter +
+		" ORDER BY next_attempt ASC LIMIT ? FOR UPDATE SKIP LOCKED) RETURNING *"
+	values := append([]interface{}{owner, now.Add(lease), models.PENDING, now, now}, args...)
+	res := db.Raw(query, append(values, limit)...).Scan(&jobs)
+	if res.Error != nil && !res.RecordNotFound() {
+		err = res.Error
+	}
+	return
+}
+
+func ClaimDueResolutionJobs(owner string, now time.Time, lease time.Duration, limit int) ([]models.ResolutionJob, error) {
+	return claimResolutionJobs("", owner, now, lease, limit)
+}
+
+// Takes a lease on the job of one game, if it is due and nobody else holds it
+func ClaimResolutionJob(owner string, gameId string, now time.Time,
+	lease time.Duration) (job models.ResolutionJob, claimed bool, err error) {
+	jobs, err := claimResolutionJobs(" AND game_id = ?", owner, now, lease, 1, gameId)
+	if err == nil && len(jobs) > 0 {
+		job = jobs[0]
+		claimed = true
+	}
+	return
+}
+
+// Records the outcome of a run and gives up the lease, unless it was lost to another server in the meantime
+func ReleaseResolutionJob(job models.ResolutionJob, owner string) (err error) {
+	res := db.Model(models.ResolutionJob{}).Where("game_id = ? AND locked_by = ?", job.GameId, owner).
+		Updates(map[string]interface{}{
+			"status":       job.Status,
+			"attempts":     job.Attempts,
+			"next_attempt": job.NextAttempt,
+			"last_error":   job.LastError,
+			"locked_by":    "",
+			"locked_until": time.Time{},
+		})
+	if res.Error != nil {
+		err = res.Error
+	} else if res.RowsAffected == 0 {
+		err = fmt.Errorf("lease on resolution job of game %s was lost", job.GameId)
+	}
+	return
+}
+
+func QueryResolutionJob(ctx context.Context, gameId string) (job models.ResolutionJob, err error) {
+	res := withContext(ctx).Where("game_id = ?", gameId).First(&job)
+	if res.RecordNotFound() {
+		err = apperrors.NotFound("no resolution job found")
+	} else if res.Error != nil {
+		err = res.Error
+	}
+	return
+}
+
+func QueryResolutionJobs(ctx context.Context, status models.JobStatus) (jobs []models.ResolutionJob, err error) {
+	res := withContext(ctx).Where("status = ?", status).Order("updated_at desc").Find(&jobs)
+	if res.Error != nil {
+		err = res.Error
+	}
+	return
+}
+
+// Puts a job that gave up back in the queue, only if it is still dead so that it never races a server running it
+func RetryDeadResolutionJob(job models.ResolutionJob) (retried bool, err error) {
+	res := db.Model(models.ResolutionJob{}).Where("game_id = ? AND status = ?", job.GameId, models.DEAD).
+		Updates(map[string]interface{}{
+			"status":       job.Status,
+			"attempts":     job.Attempts,
+			"next_attempt": job.NextAttempt,
+			"last_error":   job.LastError,
+			"locked_by":    "",
+			"locked_until": time.Time{},
+		})
+	if res.Error != nil {
+		err = res.Error
+	}
+	retried = res.RowsAffected > 0
+	return
+}
+
+/* IDEMPOTENCY_KEY CRUD */
+// Claims a key for a mutation, returning the key as first stored if it was already claimed within the window.
+// A claim blocks on any concurrent claim of the same key until that transaction commits or rolls back.
+func claimIdempotencyKey(conn *gorm.DB, userId string, key string, operation string,
+	window time.Duration) (claimed bool, existing models.IdempotencyKey, err error) {
+	// Keys are only kept for the window, after which they can be used again
+	res := conn.Where("user_id = ? AND created_at < ?", userId, time.Now().Add(-window)).Delete(models.IdempotencyKey{})
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	res = exec(conn, "INSERT idempotency_keys", "INSERT INTO idempotency_keys (user_id, key, operation, result_id, created_at) VALUES (?, ?, ?, ?, ?) "+
+		"ON CONFLICT DO NOTHING", userId, key, operation, "", time.Now())
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	if res.RowsAffected == 1 {
+		claimed = true
+		return
+	}
+	err = conn.Where("user_id = ? AND key = ?", userId, key).First(&existing).Error
+	return
+}
+
+// Key as stored by the run that claimed it within the window, if any
+func QueryIdempotencyKey(ctx context.Context, userId string, key string,
+	window time.Duration) (existing models.IdempotencyKey, found bool, err error) {
+	res := withContext(ctx).Where("user_id = ? AND key = ? AND created_at >= ?", userId, key, time.Now().Add(-window)).
+		First(&existing)
+	if res.RecordNotFound() {
+		return
+	}
+	err = res.Error
+	found = err == nil
+	return
+}
+
+func setIdempotencyResult(conn *gorm.DB, userId string, key string, resultId string) (err error) {
+	res := conn.Model(&models.IdempotencyKey{}).Where("user_id = ? AND key = ?", userId, key).
+		Update("result_id", resultId)
+	if res.Error != nil {
+		err = res.Error
+	}
+	return
+}
+
 /* HAT_CRUD */
 
 func TryCreateHat(hat models.Hat) (exists bool, err error) {
@@ -415,10 +897,10 @@ func TryCreateHat(hat models.Hat) (exists bool, err error) {
 	return
 }
 
-func QueryHat(desiredHat models.Hat) (hat models.Hat, err error) {
-	res := db.Where(desiredHat).First(&hat)
+func QueryHat(ctx context.Context, desiredHat models.Hat) (hat models.Hat, err error) {
+	res := withContext(ctx).Where(desiredHat).First(&hat)
 	if res.RecordNotFound() {
-		err = errors.New("no hat found")
+		err = apperrors.NotFound("no hat found")
 	} else if res.Error != nil {
 		err = res.Error
 	}
@@ -426,6 +908,11 @@ func QueryHat(desiredHat models.Hat) (hat models.Hat, err error) {
 	return
 }
 
+func QueryHatsByIds(ctx context.Context, ids []string) (hats []models.Hat, err error) {
+	err = withContext(ctx).Where("id IN (?)", ids).Find(&hats).Error
+	return
+}
+
 /* HAT_OWNERSHIP CRUD */
 func TryCreateHatOwnership(hatOwnership models.HatOwnership) (exists bool, err error) {
 	// Check if alr exists
@@ -443,9 +930,13 @@ func TryCreateHatOwnership(hatOwnership models.HatOwnership) (exists bool, err e
 }
 
 func QueryHatOwnership(desiredHatOwnership models.HatOwnership) (hatOwnership models.HatOwnership, err error) {
-	res := db.Where(desiredHatOwnership).First(&hatOwnership)
+	return queryHatOwnership(db, desiredHatOwnership)
+}
+
+func queryHatOwnership(conn *gorm.DB, desiredHatOwnership models.HatOwnership) (hatOwnership models.HatOwnership, err error) {
+	res := conn.Where(desiredHatOwnership).First(&hatOwnership)
 	if res.RecordNotFound() {
-		err = errors.New("no ownership found")
+		err = apperrors.NotFound("no ownership found")
 	} else if res.Error != nil {
 		err = res.Error
 	}
@@ -453,15 +944,15 @@ func QueryHatOwnership(desiredHatOwnership models.HatOwnership) (hatOwnership mo
 	return
 }
 
-func QueryUserHats(userId string, owned bool, achievement bool) (hats []models.Hat, err error) {
+func QueryUserHats(ctx context.Context, userId string, owned bool, achievement bool) (hats []models.Hat, err error) {
 	var validHatOwnerships []models.HatOwnership
-	err = db.Where("user_id = ? AND owned = ?", userId, owned).Find(&validHatOwnerships).Error
+	err = withContext(ctx).Where("user_id = ? AND owned = ?", userId, owned).Find(&validHatOwnerships).Error
 	if err != nil {
 		return
 	}
 
 	for _, hatOwnership := range validHatOwnerships {
-		desiredHat, internalErr := QueryHat(models.Hat{Id: hatOwnership.HatId})
+		desiredHat, internalErr := QueryHat(ctx, models.Hat{Id: hatOwnership.HatId})
 		if internalErr != nil {
 			err = internalErr
 			return
@@ -474,13 +965,17 @@ func QueryUserHats(userId string, owned bool, achievement bool) (hats []models.H
 }
 
 func UpdateHatOwnership(hatOwnership models.HatOwnership) (err error) {
+	return updateHatOwnership(db, hatOwnership)
+}
+
+func updateHatOwnership(conn *gorm.DB, hatOwnership models.HatOwnership) (err error) {
 	// Check if exists
 	var foundOwnership models.HatOwnership
-	if db.Where("hat_id = ? AND user_id = ?", hatOwnership.HatId, hatOwnership.UserId).First(&foundOwnership).RecordNotFound() {
-		err = errors.New("no ownership found")
+	if conn.Where("hat_id = ? AND user_id = ?", hatOwnership.HatId, hatOwnership.UserId).First(&foundOwnership).RecordNotFound() {
+		err = apperrors.NotFound("no ownership found")
 		return
 	}
-	res := db.Model(&models.HatOwnership{}).Updates(hatOwnership)
+	res := conn.Model(&models.HatOwnership{}).Updates(hatOwnership)
 	if res.Error != nil {
 		err = res.Error
 	}
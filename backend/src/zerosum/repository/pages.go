@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"time"
+)
+
+// Keys of rows in paged lists, each page starting after the row with the given key
+
+// Position of a game in lists ordered by end time
+type GameKey struct {
+	EndTime time.Time
+	Id      string
+}
+
+// Position of a vote in a player's history, ordered by the end time of its game from the newest
+type VoteKey struct {
+	EndTime time.Time
+	GameId  string
+}
+
+// Position of a user on the leaderboard, ordered by win rate from the highest
+type UserKey struct {
+	WinRate float64
+	Id      string
+}
+
+// Position of an entry in a player's ledger, ordered by creation time from the newest
+type LedgerKey struct {
+	CreatedAt time.Time
+	Id        string
+}
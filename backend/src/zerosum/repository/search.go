@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"regexp"
+	"strings"
+	"time"
+	"zerosum/models"
+)
+
+// Text search configuration games are indexed and searched with, which stems words and ignores case
+const SEARCH_CONFIG = "english"
+
+// Matches on the topic count for more than matches on an option
+const OPTION_RANK_WEIGHT = 0.5
+
+var searchWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Filters of a game search, unset filters match every game
+type GameSearch struct {
+	Query           string
+	IncludeResolved bool // whether games that ended, resolved or voided, are searched too
+	GameMode        *models.GameMode
+	Stakes          *models.Stakes
+	CreatorId       *string
+	EndsAfter       *time.Time
+	EndsBefore      *time.Time
+}
+
+// Position of a game in search results, ordered by rank from the best match
+type SearchKey struct {
+	Rank float64
+	Id   string
+}
+
+// Only options set by the creator are searched, as the answers of free text games stay hidden until they are resolved
+var (
+	topicVector  = fmt.Sprintf("to_tsvector('%s', games.topic)", SEARCH_CONFIG)
+	optionVector = fmt.Sprintf("to_tsvector('%s', options.body)", SEARCH_CONFIG)
+	tsQuery      = fmt.Sprintf("to_tsquery('%s', ?)", SEARCH_CONFIG)
+	matchSQL     = fmt.Sprintf("(%s @@ %s OR EXISTS (SELECT 1 FROM options WHERE options.game_id = games.id AND "+
+		"options.answer_key IS NULL AND %s @@ %s))", topicVector, tsQuery, optionVector, tsQuery)
+	rankSQL = fmt.Sprintf("(ts_rank(%s, %s) + %v * COALESCE((SELECT max(ts_rank(%s, %s)) FROM options "+
+		"WHERE options.game_id = games.id AND options.answer_key IS NULL), 0))::float8", topicVector, tsQuery,
+		OPTION_RANK_WEIGHT, optionVector, tsQuery)
+)
+
+// Indexes the topic and option bodies of games as they are searched
+func addSearchIndexes() (err error) {
+	// Expressions must be the same as those searched for the index to be used
+	err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_game_topic_search ON games "+
+		"USING GIN (to_tsvector('%s', topic))", SEARCH_CONFIG)).Error
+	if err == nil {
+		err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_option_body_search ON options "+
+			"USING GIN (to_tsvector('%s', body)) WHERE answer_key IS NULL", SEARCH_CONFIG)).Error
+	}
+	return
+}
+
+// Turns what a player typed into a text search query matching games with every word, or the start of it for the
+// word being typed. Empty when there are no words to search for.
+func textQuery(text string) string {
+	var terms []string
+	for _, word := range searchWord.FindAllString(strings.ToLower(text), -1) {
+		terms = append(terms, word+":*")
+	}
+	return strings.Join(terms, " & ")
+}
+
+// Text search query of what a player typed, empty when it only has stop words such as "the", as those are dropped
+// from the query and an empty query matches nothing
+func searchQuery(conn *gorm.DB, text string) (query string, err error) {
+	query = textQuery(text)
+	if query == "" {
+		return
+	}
+	var nodes int
+	err = conn.Raw(fmt.Sprintf("SELECT numnode(%s)", tsQuery), query).Row().Scan(&nodes)
+	if err != nil || nodes == 0 {
+		query = ""
+	}
+	return
+}
+
+// Keeps games whose topic or options match the query, leaving the search untouched when there is no query
+func matchText(interm *gorm.DB, query string) *gorm.DB {
+	if query == "" {
+		return interm
+	}
+	return interm.Where(matchSQL, query, query)
+}
+
+// Games matching the search, from the best match, along with the key of each
+func SearchGames(ctx context.Context, search GameSearch, after *SearchKey,
+	limit int) (games []models.Game, keys []SearchKey, err error) {
+	query, err := searchQuery(withContext(ctx), search.Query)
+	if err != nil {
+		return
+	}
+	// Every game ranks the same when there is no text to search for
+	interm := withContext(ctx).Model(&models.Game{}).Select("games.*, 0::float8 AS rank")
+	if query != "" {
+		interm = withContext(ctx).Model(&models.Game{}).Select("games.*, "+rankSQL+" AS rank", query, query)
+	}
+	interm = matchText(interm, query)
+	if !search.IncludeResolved {
+		interm = interm.Where("end_time > ? AND voided = ?", time.Now(), false)
+	}
+	if search.GameMode != nil {
+		interm = interm.Where("game_mode = ?", *search.GameMode)
+	}
+	if search.Stakes != nil {
+		interm = interm.Where("stakes = ?", *search.Stakes)
+	}
+	if search.CreatorId != nil {
+		interm = interm.Where("user_id = ?", *search.CreatorId)
+	}
+	if search.EndsAfter != nil {
+		interm = interm.Where("end_time > ?", *search.EndsAfter)
+	}
+	if search.EndsBefore != nil {
+		interm = interm.Where("end_time < ?", *search.EndsBefore)
+	}
+
+	// Ranked in a subquery, so that the page can start after the rank of the last game of the previous one
+	sql := "SELECT * FROM (?) AS ranked"
+	values := []interface{}{interm.QueryExpr()}
+	if after != nil {
+		sql += " WHERE rank < ? OR (rank = ? AND id > ?)"
+		values = append(values, after.Rank, after.Rank, after.Id)
+	}
+	sql += " ORDER BY rank DESC, id ASC LIMIT ?"
+	values = append(values, limit)
+	var rows []struct {
+		models.Game
+		Rank float64
+	}
+	err = withContext(ctx).Raw(sql, values...).Scan(&rows).Error
+	for _, row := range rows {
+		games = append(games, row.Game)
+		keys = append(keys, SearchKey{Rank: row.Rank, Id: row.Id})
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"sync"
+	"testing"
+)
+
+var searchDbOnce sync.Once
+var searchDbErr error
+
+// Connects the repository to the local test database, skipping tests that need it when it is not available
+func requireSearchDB(t *testing.T) {
+	searchDbOnce.Do(func() {
+		searchDbErr = InitDB("zerosumtest")
+	})
+	if searchDbErr != nil {
+		t.Skipf("Test database not available: %v", searchDbErr)
+	}
+}
+
+func TestTextQuery(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"", ""},
+		{"  ?! ", ""},
+		{"Cats", "cats:*"},
+		{"best cat-food, 2024", "best:* & cat:* & food:* & 2024:*"},
+		{"Café Straße", "café:* & straße:*"},
+	}
+	for _, test := range tests {
+		if query := textQuery(test.text); query != test.expected {
+			t.Errorf("Expected query %q for %q, got %q", test.expected, test.text, query)
+		}
+	}
+}
+
+func TestSearchQueryWithStopWords(t *testing.T) {
+	requireSearchDB(t)
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		// Stop words are dropped from the query, so a query of nothing but stop words searches without text
+		{"the", ""},
+		{"The a of", ""},
+		{"the concurrency", "the:* & concurrency:*"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		query, err := searchQuery(db, test.text)
+		if err != nil {
+			t.Fatalf("Failed to check query %q: %v", test.text, err)
+		}
+		if query != test.expected {
+			t.Errorf("Expected query %q for %q, got %q", test.expected, test.text, query)
+		}
+	}
+}
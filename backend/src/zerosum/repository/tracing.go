@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"github.com/jinzhu/gorm"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"zerosum/tracing"
+)
+
+// Settings a connection carries the context of its caller in, so that statements are traced as part of the
+// caller's span
+const (
+	TRACING_CONTEXT = "zerosum:tracing_context"
+	TRACING_SPAN    = "zerosum:tracing_span"
+)
+
+// Traces every statement run through gorm's callbacks. Statements on connections without a caller's context, such
+// as those run while the server starts up, start traces of their own.
+func registerTracingCallbacks(conn *gorm.DB) {
+	callbacks := conn.Callback()
+	callbacks.Create().Before("gorm:create").Register("tracing:before_create", startStatementSpan("INSERT"))
+	callbacks.Create().After("gorm:create").Register("tracing:after_create", finishStatementSpan)
+	callbacks.Query().Before("gorm:query").Register("tracing:before_query", startStatementSpan("SELECT"))
+	callbacks.Query().After("gorm:query").Register("tracing:after_query", finishStatementSpan)
+	callbacks.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", startStatementSpan("SELECT"))
+	callbacks.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", finishStatementSpan)
+	callbacks.Update().Before("gorm:update").Register("tracing:before_update", startStatementSpan("UPDATE"))
+	callbacks.Update().After("gorm:update").Register("tracing:after_update", finishStatementSpan)
+	callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startStatementSpan("DELETE"))
+	callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", finishStatementSpan)
+}
+
+// Connection outside of a transaction whose statements are traced as part of the span in ctx
+func withContext(ctx context.Context) *gorm.DB {
+	return db.Set(TRACING_CONTEXT, ctx)
+}
+
+func callerContext(conn interface {
+	Get(name string) (interface{}, bool)
+}) context.Context {
+	if value, ok := conn.Get(TRACING_CONTEXT); ok {
+		return value.(context.Context)
+	}
+	return context.Background()
+}
+
+func startStatementSpan(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		span, _ := tracing.StartSpan(callerContext(scope), "sql "+operation+" "+scope.TableName())
+		ext.DBType.Set(span, "sql")
+		scope.Set(TRACING_SPAN, span)
+	}
+}
+
+func finishStatementSpan(scope *gorm.Scope) {
+	value, ok := scope.Get(TRACING_SPAN)
+	if !ok {
+		return
+	}
+	span := value.(opentracing.Span)
+	ext.DBStatement.Set(span, scope.SQL)
+	err := scope.DB().Error
+	// Not finding a record is an answer rather than a failure
+	if gorm.IsRecordNotFoundError(err) {
+		err = nil
+	}
+	tracing.FinishSpan(span, err)
+}
+
+// Runs raw SQL, which skips gorm's callbacks, in a span of its own
+func exec(conn *gorm.DB, operation string, sql string, values ...interface{}) *gorm.DB {
+	span, _ := tracing.StartSpan(callerContext(conn), "sql "+operation)
+	ext.DBType.Set(span, "sql")
+	ext.DBStatement.Set(span, sql)
+	res := conn.Exec(sql, values...)
+	tracing.FinishSpan(span, res.Error)
+	return res
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"github.com/jinzhu/gorm"
+	"time"
+	"zerosum/models"
+	"zerosum/tracing"
+)
+
+// Groups repository calls into a single database transaction, so that they either all commit or all roll back
+type Tx struct {
+	conn *gorm.DB
+	ctx  context.Context
+}
+
+// Context of the caller that started the transaction
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+// Runs fn inside a transaction, rolling back if fn returns an error or panics and committing otherwise
+func Transaction(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	span, ctx := tracing.StartSpan(ctx, "repository.Transaction")
+	defer func() {
+		tracing.FinishSpan(span, err)
+	}()
+	// Statements in the transaction are traced as children of its span
+	conn := db.Set(TRACING_CONTEXT, ctx).Begin()
+	if conn.Error != nil {
+		err = conn.Error
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			conn.Rollback()
+			panic(r)
+		}
+	}()
+
+	err = fn(&Tx{conn: conn, ctx: ctx})
+	if err != nil {
+		conn.Rollback()
+		return
+	}
+	err = conn.Commit().Error
+	return
+}
+
+/* SETTLEMENT */
+// Records that a game is being settled, returning false if it has already been settled by an earlier (committed)
+// transaction. Concurrent claims on the same game block on each other until the first one commits or rolls back.
+func (tx *Tx) ClaimSettlement(gameId string) (claimed bool, err error) {
+	res := exec(tx.conn, "INSERT settlements", "INSERT INTO settlements (game_id, settled_at) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		gameId, time.Now())
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	claimed = res.RowsAffected == 1
+	return
+}
+
+/* GAME */
+func (tx *Tx) QueryGame(desiredGame models.Game) (models.Game, error) {
+	return queryGame(tx.conn, desiredGame)
+}
+
+// Queries a game and locks it until the transaction ends, so that its votes cannot change while it is settled
+func (tx *Tx) LockGame(gameId string) (models.Game, error) {
+	return queryGame(tx.conn.Set("gorm:query_option", "FOR UPDATE"), models.Game{Id: gameId})
+}
+
+func (tx *Tx) CreateGame(game models.Game) (models.Game, error) {
+	return createGame(tx.conn, game)
+}
+
+func (tx *Tx) UpdateGame(game models.Game) error {
+	return updateGame(tx.conn, game)
+}
+
+func (tx *Tx) QueryGameOptions(desiredGame models.Game) ([]models.Option, error) {
+	return queryGameOptions(tx.conn, desiredGame)
+}
+
+func (tx *Tx) CreateNumberResult(numberResult models.NumberResult) error {
+	return createNumberResult(tx.conn, numberResult)
+}
+
+/* OPTION */
+func (tx *Tx) QueryOption(desiredOption models.Option) (models.Option, error) {
+	return queryOption(tx.conn, desiredOption)
+}
+
+func (tx *Tx) UpdateOption(option models.Option) error {
+	return updateOption(tx.conn, option)
+}
+
+func (tx *Tx) GetOrCreateAnswerOption(gameId string, body string, answerKey string) (models.Option, error) {
+	return getOrCreateAnswerOption(tx.conn, gameId, body, answerKey)
+}
+
+/* USER */
+func (tx *Tx) QueryUser(desiredUser models.User) (models.User, error) {
+	return queryUser(tx.conn, desiredUser)
+}
+
+func (tx *Tx) UpdateUser(user models.User) error {
+	return updateUser(tx.conn, user)
+}
+
+func (tx *Tx) AdjustUserMoney(userId string, money int32) error {
+	return adjustUserMoney(tx.conn, userId, money)
+}
+
+func (tx *Tx) AddUserExperience(userId string, exp int) error {
+	return addUserExperience(tx.conn, userId, exp)
+}
+
+func (tx *Tx) RecordUserGame(userId string, win bool) error {
+	return recordUserGame(tx.conn, userId, win)
+}
+
+/* VOTE */
+func (tx *Tx) CreateVote(vote models.Vote) error {
+	return createVote(tx.conn, vote)
+}
+
+func (tx *Tx) QueryVote(desiredVote models.Vote) (models.Vote, error, bool) {
+	return queryVote(tx.conn, desiredVote)
+}
+
+func (tx *Tx) QueryAllGameVotes(desiredGame models.Game) ([]models.Vote, error) {
+	return queryAllGameVotes(tx.conn, desiredGame)
+}
+
+func (tx *Tx) QueryOptionVotes(desiredOption models.Option) ([]models.Vote, error) {
+	return queryOptionVotes(tx.conn, desiredOption)
+}
+
+func (tx *Tx) UpdateVote(vote models.Vote) error {
+	return updateVote(tx.conn, vote)
+}
+
+func (tx *Tx) ReplaceVote(vote models.Vote) error {
+	return replaceVote(tx.conn, vote)
+}
+
+func (tx *Tx) DeleteVote(vote models.Vote) error {
+	return deleteVote(tx.conn, vote)
+}
+
+/* ALLOCATION */
+func (tx *Tx) QueryGameAllocations(desiredGame models.Game) ([]models.Allocation, error) {
+	return queryGameAllocations(tx.conn, desiredGame)
+}
+
+func (tx *Tx) UpdateAllocationResult(allocation models.Allocation) error {
+	return updateAllocationResult(tx.conn, allocation)
+}
+
+/* LEDGER */
+func (tx *Tx) CreateLedgerEntry(entry models.LedgerEntry) error {
+	return createLedgerEntry(tx.conn, entry)
+}
+
+/* RESOLUTION_JOB */
+func (tx *Tx) EnqueueResolutionJob(gameId string, due time.Time) error {
+	return enqueueResolutionJob(tx.conn, gameId, due)
+}
+
+/* IDEMPOTENCY_KEY */
+func (tx *Tx) ClaimIdempotencyKey(userId string, key string, operation string,
+	window time.Duration) (bool, models.IdempotencyKey, error) {
+	return claimIdempotencyKey(tx.conn, userId, key, operation, window)
+}
+
+func (tx *Tx) SetIdempotencyResult(userId string, key string, resultId string) error {
+	return setIdempotencyResult(tx.conn, userId, key, resultId)
+}
+
+/* HAT_OWNERSHIP */
+func (tx *Tx) QueryHatOwnership(desiredHatOwnership models.HatOwnership) (models.HatOwnership, error) {
+	return queryHatOwnership(tx.conn, desiredHatOwnership)
+}
+
+// Queries a hat ownership and locks it until the transaction ends, so that a hat cannot be bought twice at once
+func (tx *Tx) LockHatOwnership(desiredHatOwnership models.HatOwnership) (models.HatOwnership, error) {
+	return queryHatOwnership(tx.conn.Set("gorm:query_option", "FOR UPDATE"), desiredHatOwnership)
+}
+
+func (tx *Tx) UpdateHatOwnership(hatOwnership models.HatOwnership) error {
+	return updateHatOwnership(tx.conn, hatOwnership)
+}
@@ -0,0 +1,31 @@
+package resolvers
+
+import (
+	"context"
+	"zerosum/models"
+)
+
+type AllocationResolver struct {
+	allocation *models.Allocation
+	resolved   bool
+}
+
+func (a *AllocationResolver) OPTION(ctx context.Context) (optionResolver *OptionResolver) {
+	option, err := loadersFromCtx(ctx).Option(a.allocation.OptionId)
+	if err == nil {
+		optionResolver = &OptionResolver{&option}
+	}
+	return
+}
+
+func (a *AllocationResolver) MONEY(ctx context.Context) *int32 {
+	return &a.allocation.Money
+}
+
+func (a *AllocationResolver) RESULT(ctx context.Context) *VoteResultResolver {
+	if a.resolved == false {
+		return nil
+	} else {
+		return &VoteResultResolver{win: a.allocation.Win, change: a.allocation.Change}
+	}
+}
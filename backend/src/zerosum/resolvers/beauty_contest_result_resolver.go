@@ -0,0 +1,50 @@
+package resolvers
+
+import (
+	"context"
+	"zerosum/models"
+)
+
+type BeautyContestResultResolver struct {
+	game *models.Game
+}
+
+type BeautyContestEntryResolver struct {
+	vote *models.Vote
+}
+
+func (b *BeautyContestResultResolver) TARGETVALUE(ctx context.Context) *float64 {
+	return &b.game.TargetValue
+}
+
+func (b *BeautyContestResultResolver) ENTRIES(ctx context.Context) *[]*BeautyContestEntryResolver {
+	votes, err := loadersFromCtx(ctx).GameVotes(b.game.Id)
+	if err != nil {
+		return nil
+	}
+	var entryResolvers []*BeautyContestEntryResolver
+	for index := range votes {
+		entryResolvers = append(entryResolvers, &BeautyContestEntryResolver{vote: &votes[index]})
+	}
+	return &entryResolvers
+}
+
+func (b *BeautyContestEntryResolver) PLAYER(ctx context.Context) (userResolver *UserResolver) {
+	user, err := loadersFromCtx(ctx).User(b.vote.UserId)
+	if err == nil {
+		userResolver = &UserResolver{user: &user}
+	}
+	return
+}
+
+func (b *BeautyContestEntryResolver) NUMBER(ctx context.Context) *float64 {
+	return b.vote.Number
+}
+
+func (b *BeautyContestEntryResolver) DISTANCE(ctx context.Context) *float64 {
+	return &b.vote.Distance
+}
+
+func (b *BeautyContestEntryResolver) WINNER(ctx context.Context) *bool {
+	return &b.vote.Win
+}
@@ -0,0 +1,283 @@
+package resolvers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"zerosum/apperrors"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+// Lists are paged Relay style, https://relay.dev/graphql/connections.htm, going forward only
+const (
+	DEFAULT_PAGE_SIZE = 20
+	MAX_PAGE_SIZE     = 100
+)
+
+// Number of edges asked for, which is fetched along with one more to tell whether there is a next page
+func pageSize(first *int32) (size int, err error) {
+	if first == nil {
+		return DEFAULT_PAGE_SIZE, nil
+	}
+	if *first < 0 {
+		err = apperrors.Validation("first must not be negative").With("first", *first)
+		return
+	}
+	size = int(*first)
+	if size > MAX_PAGE_SIZE {
+		size = MAX_PAGE_SIZE
+	}
+	return
+}
+
+// Cursors are opaque to clients, made of the name of the list they belong to and the key of their edge
+func encodeCursor(list string, key interface{}) string {
+	keyJSON, _ := json.Marshal(key)
+	return base64.URLEncoding.EncodeToString(append([]byte(list+":"), keyJSON...))
+}
+
+// Reads the key of the cursor into key, a pointer to a pointer to a key that is left nil if there is no cursor
+func decodeCursor(list string, cursor *string, key interface{}) (err error) {
+	if cursor == nil {
+		return
+	}
+	raw, err := base64.URLEncoding.DecodeString(*cursor)
+	prefix := []byte(list + ":")
+	if err != nil || !bytes.HasPrefix(raw, prefix) || json.Unmarshal(raw[len(prefix):], key) != nil {
+		err = apperrors.Validation("invalid cursor").With("after", *cursor)
+	}
+	return
+}
+
+/* PAGE INFO */
+type PageInfoResolver struct {
+	hasNextPage bool
+	startCursor *string
+	endCursor   *string
+}
+
+func newPageInfo(cursors []string, hasNextPage bool) *PageInfoResolver {
+	pageInfo := &PageInfoResolver{hasNextPage: hasNextPage}
+	if len(cursors) > 0 {
+		pageInfo.startCursor = &cursors[0]
+		pageInfo.endCursor = &cursors[len(cursors)-1]
+	}
+	return pageInfo
+}
+
+func (p *PageInfoResolver) HASNEXTPAGE(ctx context.Context) bool {
+	return p.hasNextPage
+}
+
+// Always false, as pages are only fetched going forward
+func (p *PageInfoResolver) HASPREVIOUSPAGE(ctx context.Context) bool {
+	return false
+}
+
+func (p *PageInfoResolver) STARTCURSOR(ctx context.Context) *string {
+	return p.startCursor
+}
+
+func (p *PageInfoResolver) ENDCURSOR(ctx context.Context) *string {
+	return p.endCursor
+}
+
+/* GAMES */
+type GameConnectionResolver struct {
+	edges    []*GameEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+type GameEdgeResolver struct {
+	cursor string
+	node   *GameResolver
+}
+
+// Builds a page out of the games fetched for it, which include one more game if there is a next page. Each edge
+// points at the key of its game, as given by keyOf.
+func newGameConnection(list string, games []models.Game, keyOf func(index int) interface{}, size int) *GameConnectionResolver {
+	hasNextPage := len(games) > size
+	if hasNextPage {
+		games = games[:size]
+	}
+	connection := &GameConnectionResolver{}
+	var cursors []string
+	for index := range games {
+		cursor := encodeCursor(list, keyOf(index))
+		cursors = append(cursors, cursor)
+		connection.edges = append(connection.edges, &GameEdgeResolver{cursor: cursor, node: &GameResolver{game: &games[index]}})
+	}
+	connection.pageInfo = newPageInfo(cursors, hasNextPage)
+	return connection
+}
+
+// Keys of games in lists ordered by end time
+func endTimeKeys(games []models.Game) func(index int) interface{} {
+	return func(index int) interface{} {
+		return repository.GameKey{EndTime: games[index].EndTime, Id: games[index].Id}
+	}
+}
+
+func (c *GameConnectionResolver) EDGES(ctx context.Context) []*GameEdgeResolver {
+	return c.edges
+}
+
+func (c *GameConnectionResolver) PAGEINFO(ctx context.Context) *PageInfoResolver {
+	return c.pageInfo
+}
+
+func (e *GameEdgeResolver) CURSOR(ctx context.Context) string {
+	return e.cursor
+}
+
+func (e *GameEdgeResolver) NODE(ctx context.Context) *GameResolver {
+	return e.node
+}
+
+/* VOTES */
+type VoteConnectionResolver struct {
+	edges    []*VoteEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+type VoteEdgeResolver struct {
+	cursor string
+	node   *VoteResolver
+}
+
+func newVoteConnection(list string, votes []models.Vote, keys []repository.VoteKey, size int) *VoteConnectionResolver {
+	hasNextPage := len(votes) > size
+	if hasNextPage {
+		votes = votes[:size]
+	}
+	connection := &VoteConnectionResolver{}
+	var cursors []string
+	for index := range votes {
+		cursor := encodeCursor(list, keys[index])
+		cursors = append(cursors, cursor)
+		connection.edges = append(connection.edges, &VoteEdgeResolver{cursor: cursor, node: &VoteResolver{vote: &votes[index]}})
+	}
+	connection.pageInfo = newPageInfo(cursors, hasNextPage)
+	return connection
+}
+
+func (c *VoteConnectionResolver) EDGES(ctx context.Context) []*VoteEdgeResolver {
+	return c.edges
+}
+
+func (c *VoteConnectionResolver) PAGEINFO(ctx context.Context) *PageInfoResolver {
+	return c.pageInfo
+}
+
+func (e *VoteEdgeResolver) CURSOR(ctx context.Context) string {
+	return e.cursor
+}
+
+func (e *VoteEdgeResolver) NODE(ctx context.Context) *VoteResolver {
+	return e.node
+}
+
+/* TRANSACTIONS */
+type TransactionConnectionResolver struct {
+	edges    []*TransactionEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+type TransactionEdgeResolver struct {
+	cursor string
+	node   *TransactionResolver
+}
+
+func newTransactionConnection(list string, entries []models.LedgerEntry, size int) *TransactionConnectionResolver {
+	hasNextPage := len(entries) > size
+	if hasNextPage {
+		entries = entries[:size]
+	}
+	connection := &TransactionConnectionResolver{}
+	var cursors []string
+	for index := range entries {
+		cursor := encodeCursor(list, repository.LedgerKey{CreatedAt: entries[index].CreatedAt, Id: entries[index].Id})
+		cursors = append(cursors, cursor)
+		connection.edges = append(connection.edges, &TransactionEdgeResolver{
+			cursor: cursor,
+			node:   &TransactionResolver{entry: &entries[index]},
+		})
+	}
+	connection.pageInfo = newPageInfo(cursors, hasNextPage)
+	return connection
+}
+
+func (c *TransactionConnectionResolver) EDGES(ctx context.Context) []*TransactionEdgeResolver {
+	return c.edges
+}
+
+func (c *TransactionConnectionResolver) PAGEINFO(ctx context.Context) *PageInfoResolver {
+	return c.pageInfo
+}
+
+func (e *TransactionEdgeResolver) CURSOR(ctx context.Context) string {
+	return e.cursor
+}
+
+func (e *TransactionEdgeResolver) NODE(ctx context.Context) *TransactionResolver {
+	return e.node
+}
+
+/* USERS */
+type UserConnectionResolver struct {
+	edges    []*UserEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+type UserEdgeResolver struct {
+	cursor string
+	node   *UserResolver
+}
+
+// Key of a user on the leaderboard, along with their ranking so that the next page carries on counting from it
+type leaderboardKey struct {
+	repository.UserKey
+	Ranking int32
+}
+
+// Builds a page of the leaderboard, ranking users from the one after the ranking of the previous page
+func newLeaderboardConnection(list string, users []models.User, previousRanking int32, size int) *UserConnectionResolver {
+	hasNextPage := len(users) > size
+	if hasNextPage {
+		users = users[:size]
+	}
+	connection := &UserConnectionResolver{}
+	var cursors []string
+	for index := range users {
+		ranking := previousRanking + int32(index) + 1
+		cursor := encodeCursor(list, leaderboardKey{
+			UserKey: repository.UserKey{WinRate: users[index].WinRate, Id: users[index].Id},
+			Ranking: ranking,
+		})
+		cursors = append(cursors, cursor)
+		connection.edges = append(connection.edges, &UserEdgeResolver{
+			cursor: cursor,
+			node:   &UserResolver{user: &users[index], ranking: &ranking},
+		})
+	}
+	connection.pageInfo = newPageInfo(cursors, hasNextPage)
+	return connection
+}
+
+func (c *UserConnectionResolver) EDGES(ctx context.Context) []*UserEdgeResolver {
+	return c.edges
+}
+
+func (c *UserConnectionResolver) PAGEINFO(ctx context.Context) *PageInfoResolver {
+	return c.pageInfo
+}
+
+func (e *UserEdgeResolver) CURSOR(ctx context.Context) string {
+	return e.cursor
+}
+
+func (e *UserEdgeResolver) NODE(ctx context.Context) *UserResolver {
+	return e.node
+}
@@ -0,0 +1,133 @@
+package resolvers
+
+import (
+	"context"
+	"github.com/graph-gophers/graphql-go"
+	"testing"
+	"time"
+	"zerosum/apperrors"
+	"zerosum/models"
+	"zerosum/repository"
+)
+
+func TestPageSize(t *testing.T) {
+	if size, _ := pageSize(nil); size != DEFAULT_PAGE_SIZE {
+		t.Errorf("Expected default page size, got %d", size)
+	}
+	large := int32(1000)
+	if size, _ := pageSize(&large); size != MAX_PAGE_SIZE {
+		t.Errorf("Expected page size to be capped at %d, got %d", MAX_PAGE_SIZE, size)
+	}
+	negative := int32(-1)
+	if _, err := pageSize(&negative); apperrors.CodeOf(err) != apperrors.VALIDATION_FAILED {
+		t.Errorf("Expected validation error for negative page size, got %v", err)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := repository.GameKey{EndTime: time.Date(2018, 7, 1, 12, 30, 0, 123456000, time.UTC), Id: "game"}
+	cursor := encodeCursor("activeGames", key)
+
+	var after *repository.GameKey
+	if err := decodeCursor("activeGames", &cursor, &after); err != nil || after == nil {
+		t.Fatalf("Expected cursor to decode, got %v", err)
+	}
+	if !after.EndTime.Equal(key.EndTime) || after.Id != key.Id {
+		t.Errorf("Expected key %+v, got %+v", key, after)
+	}
+
+	var none *repository.GameKey
+	if err := decodeCursor("activeGames", nil, &none); err != nil || none != nil {
+		t.Errorf("Expected no key without a cursor, got %+v, %v", none, err)
+	}
+
+	// Cursors of other lists and made up ones are rejected
+	for _, invalid := range []string{encodeCursor("votes", key), "not a cursor", ""} {
+		var other *repository.GameKey
+		if err := decodeCursor("activeGames", &invalid, &other); apperrors.CodeOf(err) != apperrors.VALIDATION_FAILED {
+			t.Errorf("Expected validation error for cursor %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestGameConnection(t *testing.T) {
+	now := time.Now()
+	games := []models.Game{
+		{Id: "first", EndTime: now},
+		{Id: "second", EndTime: now.Add(time.Hour)},
+		{Id: "third", EndTime: now.Add(2 * time.Hour)},
+	}
+	ctx := context.Background()
+
+	// One more game than the page holds is fetched when there is a next page
+	connection := newGameConnection("activeGames", games, endTimeKeys(games), 2)
+	edges, pageInfo := connection.EDGES(ctx), connection.PAGEINFO(ctx)
+	if len(edges) != 2 || !pageInfo.HASNEXTPAGE(ctx) {
+		t.Fatalf("Expected 2 edges and a next page, got %d edges", len(edges))
+	}
+	if *pageInfo.STARTCURSOR(ctx) != edges[0].CURSOR(ctx) || *pageInfo.ENDCURSOR(ctx) != edges[1].CURSOR(ctx) {
+		t.Errorf("Expected page to start and end at the cursors of its edges")
+	}
+	var after *repository.GameKey
+	endCursor := pageInfo.ENDCURSOR(ctx)
+	if err := decodeCursor("activeGames", endCursor, &after); err != nil || after.Id != "second" {
+		t.Errorf("Expected end cursor to point at the second game, got %+v, %v", after, err)
+	}
+
+	connection = newGameConnection("activeGames", games, endTimeKeys(games), 3)
+	if len(connection.EDGES(ctx)) != 3 || connection.PAGEINFO(ctx).HASNEXTPAGE(ctx) {
+		t.Errorf("Expected last page to hold every game")
+	}
+	connection = newGameConnection("activeGames", nil, endTimeKeys(nil), 3)
+	if connection.PAGEINFO(ctx).STARTCURSOR(ctx) != nil || connection.PAGEINFO(ctx).ENDCURSOR(ctx) != nil {
+		t.Errorf("Expected empty page to have no cursors")
+	}
+}
+
+func TestLeaderboardRankingCarriesOver(t *testing.T) {
+	users := []models.User{{Id: "a", WinRate: 0.9}, {Id: "b", WinRate: 0.8}, {Id: "c", WinRate: 0.7}}
+	ctx := context.Background()
+
+	connection := newLeaderboardConnection("leaderboard", users, 10, 2)
+	edges := connection.EDGES(ctx)
+	if *edges[0].NODE(ctx).RANKING(ctx) != 11 || *edges[1].NODE(ctx).RANKING(ctx) != 12 {
+		t.Errorf("Expected rankings to carry on from the previous page")
+	}
+	var after *leaderboardKey
+	if err := decodeCursor("leaderboard", connection.PAGEINFO(ctx).ENDCURSOR(ctx), &after); err != nil {
+		t.Fatalf("Expected cursor to decode, got %v", err)
+	}
+	if after.Id != "b" || after.WinRate != 0.8 || after.Ranking != 12 {
+		t.Errorf("Expected cursor of the second user with their ranking, got %+v", after)
+	}
+}
+
+func TestTransactionConnection(t *testing.T) {
+	now := time.Now()
+	entries := []models.LedgerEntry{{Id: "b", CreatedAt: now}, {Id: "a", CreatedAt: now}, {Id: "c", CreatedAt: now.Add(-time.Hour)}}
+	ctx := context.Background()
+
+	connection := newTransactionConnection("transactions", entries, 2)
+	if len(connection.EDGES(ctx)) != 2 || !connection.PAGEINFO(ctx).HASNEXTPAGE(ctx) {
+		t.Fatalf("Expected 2 edges and a next page")
+	}
+	var after *repository.LedgerKey
+	if err := decodeCursor("transactions", connection.PAGEINFO(ctx).ENDCURSOR(ctx), &after); err != nil {
+		t.Fatalf("Expected cursor to decode, got %v", err)
+	}
+	if after.Id != "a" || !after.CreatedAt.Equal(now) {
+		t.Errorf("Expected cursor of the second entry, got %+v", after)
+	}
+}
+
+func TestSearchGamesRejectsEmptyTimeRange(t *testing.T) {
+	now := time.Now()
+	_, err := (&Resolver{}).SEARCHGAMES(context.Background(), gameSearchQuery{Search: gameSearchInput{
+		Query:      "weather",
+		EndsAfter:  &graphql.Time{Time: now},
+		EndsBefore: &graphql.Time{Time: now.Add(-time.Hour)},
+	}})
+	if apperrors.CodeOf(err) != apperrors.VALIDATION_FAILED {
+		t.Errorf("Expected validation error for a range that ends before it starts, got %v", err)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/graph-gophers/graphql-go"
 	"zerosum/models"
-	"zerosum/repository"
 )
 
 type GameResolver struct {
@@ -17,10 +16,7 @@ func (g *GameResolver) ID(ctx context.Context) graphql.ID {
 
 func (g *GameResolver) OWNER(ctx context.Context) (userResolver *UserResolver) {
 
-	user, err := repository.QueryUser(models.User{
-		Id: g.game.UserId,
-	})
-
+	user, err := loadersFromCtx(ctx).User(g.game.UserId)
 	if err == nil {
 		userResolver = &UserResolver{user: &user}
 	}
@@ -40,13 +36,7 @@ func (g *GameResolver) ENDTIME(ctx context.Context) *graphql.Time {
 }
 
 func (g *GameResolver) TOTALMONEY(ctx context.Context) *int32 {
-	votes, err := repository.QueryAllGameVotes(*g.game)
-	sum := int32(0)
-	if err == nil {
-		for _, vote := range votes {
-			sum += vote.Money
-		}
-	}
+	sum, _ := loadersFromCtx(ctx).TotalMoney(g.game.Id)
 	return &sum
 }
 
@@ -58,8 +48,81 @@ func (g *GameResolver) STAKES(ctx context.Context) *models.Stakes{
 	return &g.game.Stakes
 }
 
+func (g *GameResolver) FIXEDAMOUNT(ctx context.Context) *int32 {
+	if g.game.Stakes != models.FIXED_STAKES {
+		return nil
+	}
+	return &g.game.FixedAmount
+}
+
+func (g *GameResolver) MAXAMOUNT(ctx context.Context) *int32 {
+	if g.game.Stakes != models.FIXED_LIMIT {
+		return nil
+	}
+	return &g.game.MaxAmount
+}
+
+func (g *GameResolver) RANGEMIN(ctx context.Context) *float64 {
+	if g.game.GameMode != models.BEAUTY_CONTEST {
+		return nil
+	}
+	return &g.game.RangeMin
+}
+
+func (g *GameResolver) RANGEMAX(ctx context.Context) *float64 {
+	if g.game.GameMode != models.BEAUTY_CONTEST {
+		return nil
+	}
+	return &g.game.RangeMax
+}
+
+func (g *GameResolver) TARGETFRACTION(ctx context.Context) *float64 {
+	if g.game.GameMode != models.BEAUTY_CONTEST {
+		return nil
+	}
+	return &g.game.TargetFraction
+}
+
+func (g *GameResolver) MINPARTICIPANTS(ctx context.Context) *int32 {
+	return &g.game.MinParticipants
+}
+
+func (g *GameResolver) MINPOT(ctx context.Context) *int32 {
+	return &g.game.MinPot
+}
+
+func (g *GameResolver) LOCKVOTES(ctx context.Context) *bool {
+	return &g.game.LockVotes
+}
+
+func (g *GameResolver) BEAUTYCONTESTRESULT(ctx context.Context) *BeautyContestResultResolver {
+	if g.game.GameMode != models.BEAUTY_CONTEST || !g.game.Resolved {
+		return nil
+	}
+	return &BeautyContestResultResolver{game: g.game}
+}
+
+func (g *GameResolver) NUMBERRESULTS(ctx context.Context) *[]*NumberResultResolver {
+	if g.game.GameMode != models.LOWEST_UNIQUE || !g.game.Resolved {
+		return nil
+	}
+	numberResults, err := loadersFromCtx(ctx).NumberResults(g.game.Id)
+	if err != nil {
+		return nil
+	}
+	var numberResultResolvers []*NumberResultResolver
+	for index := range numberResults {
+		numberResultResolvers = append(numberResultResolvers, &NumberResultResolver{numberResult: &numberResults[index]})
+	}
+	return &numberResultResolvers
+}
+
 func (g *GameResolver) OPTIONS(ctx context.Context) *[]*OptionResolver{
-	options, err := repository.QueryGameOptions(*g.game)
+	// Answers of a free text game stay hidden until it is resolved
+	if g.game.GameMode == models.SCHELLING && !g.game.Resolved {
+		return nil
+	}
+	options, err := loadersFromCtx(ctx).GameOptions(g.game.Id)
 	if err == nil {
 		var optionResolvers []*OptionResolver
 		for index := range options {
@@ -76,10 +139,27 @@ func (g *GameResolver) OPTIONS(ctx context.Context) *[]*OptionResolver{
 //}
 
 func (g *GameResolver) VOTED(ctx context.Context) *bool {
-	voted := repository.CheckVoted(getIdFromCtx(ctx), g.game.Id)
+	voted, _ := loadersFromCtx(ctx).Voted(g.game.Id)
 	return &voted
 }
 
 func (g *GameResolver) RESOLVED(ctx context.Context) *bool {
 	return &g.game.Resolved
-}
\ No newline at end of file
+}
+
+func (g *GameResolver) STATE(ctx context.Context) *models.GameState {
+	state := models.ACTIVE
+	if g.game.Voided {
+		state = models.VOIDED
+	} else if g.game.Resolved {
+		state = models.RESOLVED
+	}
+	return &state
+}
+
+func (g *GameResolver) VOIDREASON(ctx context.Context) *models.VoidReason {
+	if !g.game.Voided {
+		return nil
+	}
+	return &g.game.VoidReason
+}
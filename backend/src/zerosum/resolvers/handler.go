@@ -0,0 +1,159 @@
+package resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/graph-gophers/graphql-go"
+	qerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/graph-gophers/graphql-go/trace"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"zerosum/apperrors"
+	"zerosum/loaders"
+	"zerosum/logging"
+	"zerosum/metrics"
+)
+
+var (
+	operationsTotal = metrics.NewCounter("zerosum_graphql_operations_total",
+		"GraphQL operations by top level field and outcome (ok, or the code of the first error).", "operation", "outcome")
+	operationDuration = metrics.NewHistogram("zerosum_graphql_operation_duration_seconds",
+		"Time taken by GraphQL operations, by top level field and outcome.", metrics.DEFAULT_BUCKETS,
+		"operation", "outcome")
+)
+
+// Operation label of requests that did not run exactly one top level field, such as invalid queries
+const OTHER_OPERATION = "other"
+
+// Serves GraphQL requests like relay.Handler, but adds the code and details of each error to its extensions and
+// hides the message of internal errors from clients
+type Handler struct {
+	Schema *graphql.Schema
+}
+
+type responseError struct {
+	Message    string                 `json:"message"`
+	Locations  []qerrors.Location     `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+type response struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors []responseError `json:"errors,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	responseJSON, err := json.Marshal(h.exec(r.Context(), params.Query, params.OperationName, params.Variables))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
+
+func (h *Handler) exec(ctx context.Context, query string, operationName string, variables map[string]interface{}) response {
+	start := time.Now()
+	ctx = loaders.NewContext(ctx, loaders.New(ctx, requestUserId(ctx)))
+	fields := &topLevelFields{}
+	ctx = context.WithValue(ctx, topLevelFieldsKey{}, fields)
+	result := h.Schema.Exec(ctx, query, operationName, variables)
+	res := response{Data: result.Data}
+	for _, queryErr := range result.Errors {
+		res.Errors = append(res.Errors, formatError(ctx, queryErr))
+	}
+
+	operation, outcome := fields.operation(), "ok"
+	if len(res.Errors) > 0 {
+		outcome = fmt.Sprint(res.Errors[0].Extensions["code"])
+	}
+	operationsTotal.Inc(operation, outcome)
+	operationDuration.Observe(time.Since(start).Seconds(), operation, outcome)
+	return res
+}
+
+func formatError(ctx context.Context, queryErr *qerrors.QueryError) responseError {
+	formatted := responseError{
+		Message:   queryErr.Message,
+		Locations: queryErr.Locations,
+		Path:      queryErr.Path,
+	}
+	// Errors from parsing or validating the query itself are the client's to fix
+	if queryErr.ResolverError == nil {
+		formatted.Extensions = map[string]interface{}{"code": apperrors.VALIDATION_FAILED}
+		return formatted
+	}
+
+	var appErr *apperrors.Error
+	if !errors.As(queryErr.ResolverError, &appErr) {
+		logging.FromContext(ctx).Error("internal error", "error", queryErr.ResolverError, "path", queryErr.Path)
+		formatted.Message = "internal error"
+		formatted.Extensions = map[string]interface{}{"code": apperrors.INTERNAL}
+		return formatted
+	}
+	formatted.Message = appErr.Message
+	formatted.Extensions = map[string]interface{}{"code": appErr.Code}
+	for key, value := range appErr.Details {
+		formatted.Extensions[key] = value
+	}
+	return formatted
+}
+
+/* OPERATION TRACER */
+// Operations are labelled in metrics by the top level field they ran rather than the name the client gave them, so
+// that there are only ever as many labels as there are fields in the schema
+type topLevelFields struct {
+	mu    sync.Mutex
+	names []string
+}
+
+type topLevelFieldsKey struct{}
+
+// Marks the context of fields below the top level
+type nestedFieldKey struct{}
+
+func (f *topLevelFields) add(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.names = append(f.names, name)
+}
+
+func (f *topLevelFields) operation() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sort.Strings(f.names)
+	if len(f.names) == 0 || f.names[0] != f.names[len(f.names)-1] {
+		return OTHER_OPERATION
+	}
+	return f.names[0]
+}
+
+// Wraps the tracer of a schema served by Handler, recording the top level fields each operation runs
+type OperationTracer struct {
+	trace.Tracer
+}
+
+func (t OperationTracer) TraceField(ctx context.Context, label, typeName, fieldName string, trivial bool,
+	args map[string]interface{}) (context.Context, trace.TraceFieldFinishFunc) {
+	if fields, ok := ctx.Value(topLevelFieldsKey{}).(*topLevelFields); ok && ctx.Value(nestedFieldKey{}) == nil {
+		fields.add(fieldName)
+	}
+	ctx, finish := t.Tracer.TraceField(ctx, label, typeName, fieldName, trivial, args)
+	return context.WithValue(ctx, nestedFieldKey{}, true), finish
+}
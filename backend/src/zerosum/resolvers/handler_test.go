@@ -0,0 +1,108 @@
+package resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/trace"
+	"github.com/opentracing/opentracing-go"
+	"testing"
+	"zerosum/apperrors"
+	"zerosum/tracing"
+)
+
+type failingResolver struct{}
+
+func (r *failingResolver) FAIL(ctx context.Context, args struct{ Kind string }) (*int32, error) {
+	switch args.Kind {
+	case "funds":
+		return nil, apperrors.New(apperrors.INSUFFICIENT_FUNDS, "not enough money").With("amount", 100)
+	default:
+		return nil, errors.New("pq: password authentication failed")
+	}
+}
+
+func execForErrors(t *testing.T, query string, operationName string) []responseError {
+	schema := graphql.MustParseSchema(`
+		schema { query: Query }
+		type Query { fail(kind: String!): Int }
+	`, &failingResolver{}, graphql.Tracer(OperationTracer{Tracer: trace.OpenTracingTracer{}}))
+	res := (&Handler{Schema: schema}).exec(context.Background(), query, operationName, nil)
+	// Round trip through JSON, as clients see it
+	body, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("Failed to marshal response: %v", err)
+	}
+	var decoded response
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(decoded.Errors) != 1 {
+		t.Fatalf("Expected one error, got %+v", decoded.Errors)
+	}
+	return decoded.Errors
+}
+
+func TestHandlerAddsErrorCode(t *testing.T) {
+	errs := execForErrors(t, `{ fail(kind: "funds") }`, "")
+	if errs[0].Message != "not enough money" || errs[0].Extensions["code"] != string(apperrors.INSUFFICIENT_FUNDS) {
+		t.Errorf("Expected insufficient funds error, got %+v", errs[0])
+	}
+	if errs[0].Extensions["amount"] != float64(100) {
+		t.Errorf("Expected details in extensions, got %+v", errs[0].Extensions)
+	}
+}
+
+func TestHandlerHidesInternalErrors(t *testing.T) {
+	errs := execForErrors(t, `{ fail(kind: "db") }`, "")
+	if errs[0].Message != "internal error" || errs[0].Extensions["code"] != string(apperrors.INTERNAL) {
+		t.Errorf("Expected internal error to be hidden, got %+v", errs[0])
+	}
+}
+
+func TestHandlerQueryErrors(t *testing.T) {
+	errs := execForErrors(t, `{ unknown }`, "")
+	if errs[0].Extensions["code"] != string(apperrors.VALIDATION_FAILED) {
+		t.Errorf("Expected validation error for unknown field, got %+v", errs[0])
+	}
+}
+
+func TestHandlerTracesOperationsAndFields(t *testing.T) {
+	tracer := tracing.NewMemoryTracer(10)
+	tracing.Init(tracer)
+	defer tracing.Init(opentracing.NoopTracer{})
+
+	query := `{ fail(kind: "funds") }`
+	execForErrors(t, query, "")
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected spans for operation and field, got %+v", spans)
+	}
+	field, operation := spans[0], spans[1]
+	if operation.Tag("graphql.query") != query || field.Tag("graphql.field") != "fail" {
+		t.Errorf("Expected operation and field spans, got %+v and %+v", operation, field)
+	}
+	if field.ParentId != operation.Context().(tracing.MemorySpanContext).SpanId || field.Tag("error") != true {
+		t.Errorf("Expected failed field span under operation span, got %+v", field)
+	}
+}
+
+func TestHandlerLabelsOperationsByField(t *testing.T) {
+	funds := string(apperrors.INSUFFICIENT_FUNDS)
+	failed, other := operationsTotal.Value("fail", funds), operationsTotal.Value(OTHER_OPERATION, funds)
+
+	// Names clients give their operations are not used, as there is no limit to how many they could make up
+	execForErrors(t, `query madeUp { fail(kind: "funds") }`, "madeUp")
+	if value := operationsTotal.Value("fail", funds); value != failed+1 {
+		t.Errorf("Expected operations to be labelled by their top level field, got %v", value-failed)
+	}
+	if value := operationsTotal.Value("madeUp", funds); value != 0 {
+		t.Errorf("Expected no operations labelled by operation name, got %v", value)
+	}
+
+	execForErrors(t, `{ fail(kind: "funds") __typename }`, "")
+	if value := operationsTotal.Value(OTHER_OPERATION, funds); value != other+1 {
+		t.Errorf("Expected operations running several fields to be labelled %s, got %v", OTHER_OPERATION, value-other)
+	}
+}
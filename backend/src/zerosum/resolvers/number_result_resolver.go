@@ -0,0 +1,26 @@
+package resolvers
+
+import (
+	"context"
+	"zerosum/models"
+)
+
+type NumberResultResolver struct {
+	numberResult *models.NumberResult
+}
+
+func (n *NumberResultResolver) NUMBER(ctx context.Context) *int32 {
+	return &n.numberResult.Number
+}
+
+func (n *NumberResultResolver) VOTECOUNT(ctx context.Context) *int32 {
+	return &n.numberResult.TotalVotes
+}
+
+func (n *NumberResultResolver) TOTALVALUE(ctx context.Context) *int32 {
+	return &n.numberResult.TotalValue
+}
+
+func (n *NumberResultResolver) WINNER(ctx context.Context) *bool {
+	return &n.numberResult.Winner
+}
@@ -0,0 +1,39 @@
+package resolvers
+
+import (
+	"context"
+	"github.com/graph-gophers/graphql-go"
+	"zerosum/models"
+)
+
+type ResolutionJobResolver struct {
+	job *models.ResolutionJob
+}
+
+func (j *ResolutionJobResolver) GAME(ctx context.Context) (gameResolver *GameResolver) {
+	game, err := loadersFromCtx(ctx).Game(j.job.GameId)
+	if err == nil {
+		gameResolver = &GameResolver{game: &game}
+	}
+	return
+}
+
+func (j *ResolutionJobResolver) STATUS(ctx context.Context) *models.JobStatus {
+	return &j.job.Status
+}
+
+func (j *ResolutionJobResolver) ATTEMPTS(ctx context.Context) *int32 {
+	return &j.job.Attempts
+}
+
+func (j *ResolutionJobResolver) NEXTATTEMPT(ctx context.Context) *graphql.Time {
+	return &graphql.Time{Time: j.job.NextAttempt}
+}
+
+func (j *ResolutionJobResolver) LASTERROR(ctx context.Context) *string {
+	return &j.job.LastError
+}
+
+func (j *ResolutionJobResolver) UPDATEDAT(ctx context.Context) *graphql.Time {
+	return &graphql.Time{Time: j.job.UpdatedAt}
+}
@@ -2,9 +2,11 @@ package resolvers
 
 import (
 	"context"
-	"errors"
+	"github.com/graph-gophers/graphql-go"
 	"os"
 	"time"
+	"zerosum/apperrors"
+	"zerosum/loaders"
 	"zerosum/logic"
 	"zerosum/models"
 	"zerosum/repository"
@@ -16,11 +18,35 @@ type activeGameSearchQuery struct {
 	Filter  string
 	Joined  *bool
 	Created *bool
-	Limit   *int32
+	First   *int32
+	After   *string
 }
 
 type completedGameSearchQuery struct {
 	Created bool
+	First   *int32
+	After   *string
+}
+
+type gameSearchInput struct {
+	Query           string
+	IncludeResolved *bool
+	GameMode        *models.GameMode
+	Stakes          *models.Stakes
+	CreatorId       *graphql.ID
+	EndsAfter       *graphql.Time
+	EndsBefore      *graphql.Time
+}
+
+type gameSearchQuery struct {
+	Search gameSearchInput
+	First  *int32
+	After  *string
+}
+
+type leaderboardQuery struct {
+	First *int32
+	After *string
 }
 
 type voteQuery struct {
@@ -28,8 +54,13 @@ type voteQuery struct {
 }
 
 type userVoteQuery struct {
-	Limit *int32
-	After *int32
+	First *int32
+	After *string
+}
+
+type transactionQuery struct {
+	First *int32
+	After *string
 }
 
 //type userInput struct {
@@ -37,19 +68,43 @@ type userVoteQuery struct {
 //}
 
 type gameInput struct {
-	Topic    string
-	Duration int32
-	GameMode models.GameMode
-	Stakes   models.Stakes
-	Options  []string
+	Topic           string
+	Duration        int32
+	GameMode        models.GameMode
+	Stakes          models.Stakes
+	FixedAmount     *int32
+	MaxAmount       *int32
+	RangeMin        *float64
+	RangeMax        *float64
+	TargetFraction  *float64
+	MinParticipants *int32
+	MinPot          *int32
+	LockVotes       *bool
+	Options         []string
 }
 
 type voteInput struct {
-	GameId   string
+	GameId      string
+	OptionId    *string
+	Allocations *[]allocationInput
+	Number      *float64
+	Answer      *string
+	Amount      int32
+}
+
+type allocationInput struct {
 	OptionId string
 	Amount   int32
 }
 
+// Idempotency keys are optional, mutations without one always run
+func idempotencyKey(key *string) string {
+	if key == nil {
+		return ""
+	}
+	return *key
+}
+
 func getIdFromCtx(ctx context.Context) (id string) {
 	if os.Getenv("DEBUG") == "TRUE" {
 		return "testuser"
@@ -57,79 +112,218 @@ func getIdFromCtx(ctx context.Context) (id string) {
 	return ctx.Value("Id").(string)
 }
 
+// Like getIdFromCtx, but empty for requests without a user rather than panicking
+func requestUserId(ctx context.Context) string {
+	if os.Getenv("DEBUG") == "TRUE" {
+		return "testuser"
+	}
+	id, _ := ctx.Value("Id").(string)
+	return id
+}
+
+// Loaders shared by the resolvers of the request, or fresh ones for resolvers run outside of Handler
+func loadersFromCtx(ctx context.Context) *loaders.Loaders {
+	if l, ok := loaders.FromContext(ctx); ok {
+		return l
+	}
+	return loaders.New(ctx, requestUserId(ctx))
+}
+
+// Returns an error unless the user making the request is an admin
+func requireAdmin(ctx context.Context, message string) (err error) {
+	user, err := repository.QueryUser(ctx, models.User{Id: getIdFromCtx(ctx)})
+	if err == nil && !user.Admin {
+		err = apperrors.Unauthorized(message)
+	}
+	return
+}
+
 func (r *Resolver) USER(ctx context.Context, args *struct{ Id *string }) (*UserResolver, error) {
 	// TODO: Add field restriction when Id != Id in ctx
 	if args.Id == nil {
-		user, err := repository.QueryUser(models.User{Id: getIdFromCtx(ctx)})
+		user, err := repository.QueryUser(ctx, models.User{Id: getIdFromCtx(ctx)})
 		return &UserResolver{user: &user}, err
 	} else {
-		user, err := repository.QueryUser(models.User{Id: *args.Id})
+		user, err := repository.QueryUser(ctx, models.User{Id: *args.Id})
 		return &UserResolver{user: &user}, err
 	}
 }
 
 func (r *Resolver) PROFILE(ctx context.Context) (*UserResolver, error) {
-	user, err := repository.QueryUser(models.User{Id: getIdFromCtx(ctx)})
+	user, err := repository.QueryUser(ctx, models.User{Id: getIdFromCtx(ctx)})
 	return &UserResolver{user: &user}, err
 }
 
 func (r *Resolver) GAME(ctx context.Context, args *struct{ Id string }) (*GameResolver, error) {
-	game, err := repository.QueryGame(models.Game{Id: args.Id})
+	game, err := repository.QueryGame(ctx, models.Game{Id: args.Id})
 	return &GameResolver{game: &game}, err
 }
 
-func (r *Resolver) ACTIVEGAMES(ctx context.Context, args activeGameSearchQuery) (gameResolvers []*GameResolver, err error) {
-	games, err := repository.SearchActiveGames(args.Filter, args.Joined, args.Created, getIdFromCtx(ctx), args.Limit)
-	var gamesList []*GameResolver
-	for index := range games {
-		gamesList = append(gamesList, &GameResolver{game: &games[index]})
+func (r *Resolver) ACTIVEGAMES(ctx context.Context, args activeGameSearchQuery) (connection *GameConnectionResolver, err error) {
+	size, err := pageSize(args.First)
+	if err != nil {
+		return
 	}
-	gameResolvers = gamesList
+	var after *repository.GameKey
+	if err = decodeCursor("activeGames", args.After, &after); err != nil {
+		return
+	}
+	games, err := repository.SearchActiveGames(ctx, args.Filter, args.Joined, args.Created, getIdFromCtx(ctx), after, size+1)
+	if err != nil {
+		return
+	}
+	loadersFromCtx(ctx).PrimeGames(games)
+	connection = newGameConnection("activeGames", games, endTimeKeys(games), size)
 	return
 }
 
-func (r *Resolver) COMPLETEDGAMES(ctx context.Context, args completedGameSearchQuery) (gameResolvers []*GameResolver, err error) {
-	games, err := repository.GetCompletedGames(getIdFromCtx(ctx), args.Created)
-	var gamesList []*GameResolver
-	for index := range games {
-		gamesList = append(gamesList, &GameResolver{game: &games[index]})
+func (r *Resolver) COMPLETEDGAMES(ctx context.Context, args completedGameSearchQuery) (connection *GameConnectionResolver, err error) {
+	size, err := pageSize(args.First)
+	if err != nil {
+		return
+	}
+	var after *repository.GameKey
+	if err = decodeCursor("completedGames", args.After, &after); err != nil {
+		return
+	}
+	games, err := repository.GetCompletedGames(ctx, getIdFromCtx(ctx), args.Created, after, size+1)
+	if err != nil {
+		return
 	}
-	gameResolvers = gamesList
+	loadersFromCtx(ctx).PrimeGames(games)
+	connection = newGameConnection("completedGames", games, endTimeKeys(games), size)
+	return
+}
+
+func (r *Resolver) SEARCHGAMES(ctx context.Context, args gameSearchQuery) (connection *GameConnectionResolver, err error) {
+	size, err := pageSize(args.First)
+	if err != nil {
+		return
+	}
+	var after *repository.SearchKey
+	if err = decodeCursor("searchGames", args.After, &after); err != nil {
+		return
+	}
+	input := args.Search
+	search := repository.GameSearch{Query: input.Query, GameMode: input.GameMode, Stakes: input.Stakes}
+	if input.IncludeResolved != nil {
+		search.IncludeResolved = *input.IncludeResolved
+	}
+	if input.CreatorId != nil {
+		creatorId := string(*input.CreatorId)
+		search.CreatorId = &creatorId
+	}
+	if input.EndsAfter != nil {
+		search.EndsAfter = &input.EndsAfter.Time
+	}
+	if input.EndsBefore != nil {
+		search.EndsBefore = &input.EndsBefore.Time
+	}
+	if search.EndsAfter != nil && search.EndsBefore != nil && !search.EndsAfter.Before(*search.EndsBefore) {
+		err = apperrors.Validation("endsAfter must be before endsBefore")
+		return
+	}
+
+	games, keys, err := repository.SearchGames(ctx, search, after, size+1)
+	if err != nil {
+		return
+	}
+	loadersFromCtx(ctx).PrimeGames(games)
+	connection = newGameConnection("searchGames", games, func(index int) interface{} { return keys[index] }, size)
 	return
 }
 
 func (r *Resolver) GAMECOUNT(ctx context.Context) (total int32) {
-	return repository.CountGames()
+	return repository.CountGames(ctx)
 }
 
-func (r *Resolver) LEADERBOARD(ctx context.Context, args *struct{ Limit int32 }) (userResolvers []*UserResolver, err error) {
-	users, err := repository.QueryTopUsers(10, logic.LEADERBOARD_MIN_GAMES)
-	var userList []*UserResolver
-	for index := range users {
-		ranking := int32(index) + 1
-		userList = append(userList, &UserResolver{user: &users[index], ranking: &ranking})
+func (r *Resolver) LEADERBOARD(ctx context.Context, args leaderboardQuery) (connection *UserConnectionResolver, err error) {
+	size, err := pageSize(args.First)
+	if err != nil {
+		return
+	}
+	var after *leaderboardKey
+	if err = decodeCursor("leaderboard", args.After, &after); err != nil {
+		return
+	}
+	var afterKey *repository.UserKey
+	var previousRanking int32
+	if after != nil {
+		afterKey, previousRanking = &after.UserKey, after.Ranking
+	}
+	users, err := repository.QueryTopUsers(ctx, logic.LEADERBOARD_MIN_GAMES, afterKey, size+1)
+	if err != nil {
+		return
 	}
-	userResolvers = userList
+	connection = newLeaderboardConnection("leaderboard", users, previousRanking, size)
 	return
 }
 
 func (r *Resolver) VOTE(ctx context.Context, args voteQuery) (*VoteResolver, error) {
-	vote, err, _ := repository.QueryVote(models.Vote{GameId: args.GameId, UserId: getIdFromCtx(ctx)})
+	vote, err, _ := repository.QueryVote(ctx, models.Vote{GameId: args.GameId, UserId: getIdFromCtx(ctx)})
 	return &VoteResolver{vote: &vote}, err
 }
 
-func (r *Resolver) VOTES(ctx context.Context, args userVoteQuery) (voteResolvers []*VoteResolver, err error) {
-	votes, err := repository.QueryVotes(models.Vote{UserId: getIdFromCtx(ctx)}, args.Limit, args.After)
-	var voteList []*VoteResolver
-	for index := range votes {
-		voteList = append(voteList, &VoteResolver{vote: &votes[index]})
+func (r *Resolver) VOTES(ctx context.Context, args userVoteQuery) (connection *VoteConnectionResolver, err error) {
+	size, err := pageSize(args.First)
+	if err != nil {
+		return
+	}
+	var after *repository.VoteKey
+	if err = decodeCursor("votes", args.After, &after); err != nil {
+		return
+	}
+	votes, keys, err := repository.QueryUserVotes(ctx, getIdFromCtx(ctx), after, size+1)
+	if err != nil {
+		return
+	}
+	loadersFromCtx(ctx).PrimeVotes(votes)
+	connection = newVoteConnection("votes", votes, keys, size)
+	return
+}
+
+func (r *Resolver) TRANSACTIONS(ctx context.Context, args transactionQuery) (connection *TransactionConnectionResolver, err error) {
+	size, err := pageSize(args.First)
+	if err != nil {
+		return
+	}
+	var after *repository.LedgerKey
+	if err = decodeCursor("transactions", args.After, &after); err != nil {
+		return
+	}
+	entries, err := repository.QueryUserLedgerEntries(ctx, getIdFromCtx(ctx), after, size+1)
+	if err != nil {
+		return
+	}
+	for index := range entries {
+		if entries[index].GameId != "" {
+			loadersFromCtx(ctx).QueueGames(entries[index].GameId)
+		}
+		if entries[index].HatId != "" {
+			loadersFromCtx(ctx).QueueHats(entries[index].HatId)
+		}
+	}
+	connection = newTransactionConnection("transactions", entries, size)
+	return
+}
+
+func (r *Resolver) FAILEDRESOLUTIONS(ctx context.Context) (jobResolvers []*ResolutionJobResolver, err error) {
+	err = requireAdmin(ctx, "not allowed to view failed resolutions")
+	if err != nil {
+		return
+	}
+	jobs, err := repository.QueryResolutionJobs(ctx, models.DEAD)
+	var jobList []*ResolutionJobResolver
+	for index := range jobs {
+		loadersFromCtx(ctx).QueueGames(jobs[index].GameId)
+		jobList = append(jobList, &ResolutionJobResolver{job: &jobs[index]})
 	}
-	voteResolvers = voteList
+	jobResolvers = jobList
 	return
 }
 
 func (r *Resolver) STOREHATS(ctx context.Context, args *struct{ Owned bool }) (hatResolvers []*HatResolver, err error) {
-	hats, err := repository.QueryUserHats(getIdFromCtx(ctx), args.Owned, false)
+	hats, err := repository.QueryUserHats(ctx, getIdFromCtx(ctx), args.Owned, false)
 	var hatList []*HatResolver
 	for index := range hats {
 		hatList = append(hatList, &HatResolver{hat: &hats[index], owned: args.Owned, achieved: false})
@@ -139,7 +333,7 @@ func (r *Resolver) STOREHATS(ctx context.Context, args *struct{ Owned bool }) (h
 }
 
 func (r *Resolver) ACHIEVEDHATS(ctx context.Context) (hatResolvers []*HatResolver, err error) {
-	hats, err := repository.QueryUserHats(getIdFromCtx(ctx), true, true)
+	hats, err := repository.QueryUserHats(ctx, getIdFromCtx(ctx), true, true)
 	var hatList []*HatResolver
 	for index := range hats {
 		hatList = append(hatList, &HatResolver{hat: &hats[index], owned: true, achieved: true})
@@ -163,81 +357,208 @@ func (r *Resolver) DeleteUser(ctx context.Context) (success bool) {
 	return
 }
 
-func (r *Resolver) AddGame(ctx context.Context, args *struct{ Game gameInput }) (gameResolver *GameResolver, err error) {
+func (r *Resolver) AddGame(ctx context.Context, args *struct {
+	Game           gameInput
+	IdempotencyKey *string
+}) (gameResolver *GameResolver, err error) {
 	var options []models.Option
 	for _, option := range args.Game.Options {
 		options = append(options, models.Option{Body: option})
 	}
-	if len(options) < 2 {
-		err = errors.New("too few options")
-		return
-	}
-
-	if args.Game.Topic == "" {
-		err = errors.New("empty topic")
-		return
-	}
 
+	now := time.Now()
 	newGame := models.Game{
 		Topic:     args.Game.Topic,
 		UserId:    getIdFromCtx(ctx),
-		StartTime: time.Now(),
-		EndTime:   time.Now().Add(time.Minute * time.Duration(args.Game.Duration)),
+		StartTime: now,
+		EndTime:   now.Add(time.Minute * time.Duration(args.Game.Duration)),
 		Stakes:    args.Game.Stakes,
 		GameMode:  args.Game.GameMode,
 		Options:   options,
 	}
+	if args.Game.FixedAmount != nil {
+		newGame.FixedAmount = *args.Game.FixedAmount
+	}
+	if args.Game.MaxAmount != nil {
+		newGame.MaxAmount = *args.Game.MaxAmount
+	}
+	if args.Game.RangeMin != nil {
+		newGame.RangeMin = *args.Game.RangeMin
+	}
+	if args.Game.RangeMax != nil {
+		newGame.RangeMax = *args.Game.RangeMax
+	}
+	if args.Game.TargetFraction != nil {
+		newGame.TargetFraction = *args.Game.TargetFraction
+	}
+	newGame.MinParticipants = logic.DEFAULT_MIN_PARTICIPANTS
+	if args.Game.MinParticipants != nil {
+		newGame.MinParticipants = *args.Game.MinParticipants
+	}
+	if args.Game.MinPot != nil {
+		newGame.MinPot = *args.Game.MinPot
+	}
+	if args.Game.LockVotes != nil {
+		newGame.LockVotes = *args.Game.LockVotes
+	}
+	err = logic.ValidateNewGame(&newGame)
+	if err != nil {
+		return
+	}
 
-	err = logic.AllocateHostExp(getIdFromCtx(ctx))
+	game, err := logic.CreateGame(ctx, newGame, idempotencyKey(args.IdempotencyKey))
 	if err == nil {
-		game, err := repository.CreateGame(newGame)
-		logic.Controller.AddGame(&game)
-		if err == nil {
-			gameRes := GameResolver{game: &game}
-			gameResolver = &gameRes
+		gameResolver = &GameResolver{game: &game}
+	}
+	return
+}
+
+// Builds and validates a vote from the input, taking the stake it places from the amount
+func buildVote(ctx context.Context, game models.Game, input voteInput) (newVote models.Vote, err error) {
+	stake, err := logic.VoteStake(game, input.Amount)
+	if err != nil {
+		return
+	}
+
+	newVote = models.Vote{
+		GameId: input.GameId,
+		UserId: getIdFromCtx(ctx),
+		Number: input.Number,
+		Money:  stake,
+	}
+	if input.Allocations != nil {
+		if input.OptionId != nil {
+			err = logic.ErrOptionAndAllocations
+			return
 		}
+		for _, allocation := range *input.Allocations {
+			newVote.Allocations = append(newVote.Allocations, models.Allocation{
+				OptionId: allocation.OptionId,
+				Money:    allocation.Amount,
+			})
+		}
+	} else if input.OptionId != nil {
+		newVote.Allocations = []models.Allocation{{OptionId: *input.OptionId, Money: stake}}
+	}
+	// Only votes on a single option keep it on the vote itself
+	if len(newVote.Allocations) == 1 {
+		newVote.OptionId = newVote.Allocations[0].OptionId
+	}
+	if input.Answer != nil {
+		newVote.Answer = *input.Answer
+	}
+
+	options, err := repository.QueryGameOptions(ctx, game)
+	if err != nil {
+		return
 	}
+	err = logic.ValidateNewVote(game, options, &newVote, time.Now())
 	return
 }
 
-func (r *Resolver) AddVote(ctx context.Context, args *struct{ Vote voteInput }) (voteResolver *VoteResolver, err error) {
-	newVote := models.Vote{
-		GameId:   args.Vote.GameId,
-		UserId:   getIdFromCtx(ctx),
-		OptionId: args.Vote.OptionId,
-		Money:    args.Vote.Amount,
+func (r *Resolver) CancelGame(ctx context.Context, args *struct{ Id string }) (gameResolver *GameResolver, err error) {
+	game, err := repository.QueryGame(ctx, models.Game{Id: args.Id})
+	if err != nil {
+		return
+	}
+	// Only the creator or an admin may cancel a game, and only an admin once it has ended
+	adminErr := requireAdmin(ctx, "not allowed to cancel game")
+	if game.UserId != getIdFromCtx(ctx) && adminErr != nil {
+		err = adminErr
+		return
 	}
 
-	// TODO: Add validation for correct choice Id
-	if args.Vote.Amount <= 0 {
-		err = errors.New("invalid amount specified")
+	err = logic.VoidGame(ctx, game.Id, models.CANCELLED, adminErr == nil)
+	if err != nil {
 		return
 	}
-	
-	err = logic.AllocateMoney(getIdFromCtx(ctx), -args.Vote.Amount)
+	game, err = repository.QueryGame(ctx, models.Game{Id: game.Id})
+	if err == nil {
+		gameResolver = &GameResolver{game: &game}
+	}
+	return
+}
+
+func (r *Resolver) RetryResolution(ctx context.Context, args *struct{ GameId string }) (jobResolver *ResolutionJobResolver, err error) {
+	err = requireAdmin(ctx, "not allowed to retry resolutions")
 	if err != nil {
 		return
 	}
-	err = logic.AllocateVoteExp(getIdFromCtx(ctx))
+	job, err := logic.RetryResolution(ctx, args.GameId)
 	if err == nil {
-		err = repository.CreateVote(newVote)
-		vote, err, _ := repository.QueryVote(newVote)
-		if err == nil {
-			voteRes := VoteResolver{vote: &vote}
-			voteResolver = &voteRes
-		}
+		jobResolver = &ResolutionJobResolver{job: &job}
+	}
+	return
+}
+
+func (r *Resolver) AddVote(ctx context.Context, args *struct {
+	Vote           voteInput
+	IdempotencyKey *string
+}) (voteResolver *VoteResolver, err error) {
+	userId := getIdFromCtx(ctx)
+	// Retries of a vote already placed are answered with it, even if the game has ended since
+	placed, err := logic.VotePlaced(ctx, userId, args.Vote.GameId, idempotencyKey(args.IdempotencyKey))
+	if err == nil && !placed {
+		err = placeNewVote(ctx, args.Vote, idempotencyKey(args.IdempotencyKey))
+	}
+	if err != nil {
+		return
+	}
+	vote, err, _ := repository.QueryVote(ctx, models.Vote{GameId: args.Vote.GameId, UserId: userId})
+	if err == nil {
+		voteResolver = &VoteResolver{vote: &vote}
+	}
+	return
+}
+
+func placeNewVote(ctx context.Context, input voteInput, key string) (err error) {
+	game, err := repository.QueryGame(ctx, models.Game{Id: input.GameId})
+	if err != nil {
+		return
+	}
+	newVote, err := buildVote(ctx, game, input)
+	if err == nil {
+		err = logic.PlaceVote(ctx, newVote, key)
 	}
 	return
 }
 
-func (r *Resolver) BuyHat(ctx context.Context, args *struct{ Id string }) (hatResolver *HatResolver, err error) {
+func (r *Resolver) ChangeVote(ctx context.Context, args *struct{ Vote voteInput }) (voteResolver *VoteResolver, err error) {
+	game, err := repository.QueryGame(ctx, models.Game{Id: args.Vote.GameId})
+	if err != nil {
+		return
+	}
+	newVote, err := buildVote(ctx, game, args.Vote)
+	if err != nil {
+		return
+	}
+	err = logic.ChangeVote(ctx, newVote)
+	if err != nil {
+		return
+	}
+	vote, err, _ := repository.QueryVote(ctx, models.Vote{GameId: newVote.GameId, UserId: newVote.UserId})
+	if err == nil {
+		voteResolver = &VoteResolver{vote: &vote}
+	}
+	return
+}
+
+func (r *Resolver) WithdrawVote(ctx context.Context, args *struct{ GameId string }) (success bool, err error) {
+	err = logic.WithdrawVote(ctx, getIdFromCtx(ctx), args.GameId)
+	success = err == nil
+	return
+}
+
+func (r *Resolver) BuyHat(ctx context.Context, args *struct {
+	Id             string
+	IdempotencyKey *string
+}) (hatResolver *HatResolver, err error) {
 
-	desiredHat, err := repository.QueryHat(models.Hat{Id: args.Id})
-	err = logic.AllocateMoney(getIdFromCtx(ctx), -desiredHat.Price)
+	desiredHat, err := repository.QueryHat(ctx, models.Hat{Id: args.Id})
 	if err != nil {
 		return
 	}
-	err = repository.UpdateHatOwnership(models.HatOwnership{HatId: desiredHat.Id, UserId: getIdFromCtx(ctx), Owned: true})
+	err = logic.BuyHat(ctx, getIdFromCtx(ctx), desiredHat, idempotencyKey(args.IdempotencyKey))
 	if err == nil {
 		hatResolver = &HatResolver{hat: &desiredHat, owned: true, achieved: false}
 	}
@@ -245,7 +566,7 @@ func (r *Resolver) BuyHat(ctx context.Context, args *struct{ Id string }) (hatRe
 }
 
 func (r *Resolver) ValidateResult(ctx context.Context, args *struct{ GameId string }) (success bool, err error) {
-	game, err := repository.QueryGame(models.Game{Id: args.GameId})
+	game, err := repository.QueryGame(ctx, models.Game{Id: args.GameId})
 	if err != nil {
 		success = false
 		return
@@ -263,7 +584,7 @@ func (r *Resolver) ValidateResult(ctx context.Context, args *struct{ GameId stri
 	}
 
 	// Is voter
-	vote, internalErr, recordNotFound := repository.QueryVote(models.Vote{UserId: getIdFromCtx(ctx), GameId: game.Id})
+	vote, internalErr, recordNotFound := repository.QueryVote(ctx, models.Vote{UserId: getIdFromCtx(ctx), GameId: game.Id})
 	if internalErr != nil && !recordNotFound {
 		err = internalErr
 		success = false
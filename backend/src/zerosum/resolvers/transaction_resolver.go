@@ -0,0 +1,54 @@
+package resolvers
+
+import (
+	"context"
+	"github.com/graph-gophers/graphql-go"
+	"zerosum/models"
+)
+
+type TransactionResolver struct {
+	entry *models.LedgerEntry
+}
+
+func (t *TransactionResolver) ID(ctx context.Context) graphql.ID {
+	return graphql.ID(t.entry.Id)
+}
+
+func (t *TransactionResolver) KIND(ctx context.Context) *models.EntryKind {
+	return &t.entry.Kind
+}
+
+// Signed from the point of view of the player, negative when money was taken from them
+func (t *TransactionResolver) AMOUNT(ctx context.Context) *int32 {
+	amount := t.entry.Amount
+	if t.entry.Debit == models.UserAccount(t.entry.UserId) {
+		amount = -amount
+	}
+	return &amount
+}
+
+func (t *TransactionResolver) TIME(ctx context.Context) *graphql.Time {
+	return &graphql.Time{Time: t.entry.CreatedAt}
+}
+
+func (t *TransactionResolver) GAME(ctx context.Context) (gameResolver *GameResolver) {
+	if t.entry.GameId == "" {
+		return
+	}
+	game, err := loadersFromCtx(ctx).Game(t.entry.GameId)
+	if err == nil {
+		gameResolver = &GameResolver{game: &game}
+	}
+	return
+}
+
+func (t *TransactionResolver) HAT(ctx context.Context) (hatResolver *HatResolver) {
+	if t.entry.HatId == "" {
+		return
+	}
+	hat, err := loadersFromCtx(ctx).Hat(t.entry.HatId)
+	if err == nil {
+		hatResolver = &HatResolver{hat: &hat, owned: true, achieved: false}
+	}
+	return
+}
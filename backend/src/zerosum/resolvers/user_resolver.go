@@ -59,7 +59,7 @@ func (u *UserResolver) RANKING(ctx context.Context) *int32 {
 	}
 
 	// Find user from rank list
-	users, err := repository.QueryRankedUsers(logic.LEADERBOARD_MIN_GAMES)
+	users, err := repository.QueryRankedUsers(ctx, logic.LEADERBOARD_MIN_GAMES)
 	if err != nil {
 		return nil
 	}
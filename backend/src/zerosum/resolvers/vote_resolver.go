@@ -3,7 +3,6 @@ package resolvers
 import (
 	"context"
 	"zerosum/models"
-	"zerosum/repository"
 )
 
 type VoteResolver struct {
@@ -11,10 +10,7 @@ type VoteResolver struct {
 }
 
 func (v *VoteResolver) GAME(ctx context.Context) (gameResolver *GameResolver) {
-	game, err := repository.QueryGame(models.Game{
-		Id: v.vote.GameId,
-	})
-
+	game, err := loadersFromCtx(ctx).Game(v.vote.GameId)
 	if err == nil {
 		gameResolver = &GameResolver{&game}
 	}
@@ -22,16 +18,52 @@ func (v *VoteResolver) GAME(ctx context.Context) (gameResolver *GameResolver) {
 }
 
 func (v *VoteResolver) OPTION(ctx context.Context) (optionResolver *OptionResolver) {
-	option, err := repository.QueryOption(models.Option{
-		Id: v.vote.OptionId,
-	})
-
+	// Numeric games have no option
+	if v.vote.OptionId == "" {
+		return
+	}
+	option, err := loadersFromCtx(ctx).Option(v.vote.OptionId)
 	if err == nil {
 		optionResolver = &OptionResolver{&option}
 	}
 	return
 }
 
+func (v *VoteResolver) ALLOCATIONS(ctx context.Context) *[]*AllocationResolver {
+	allocations, err := loadersFromCtx(ctx).Allocations(v.vote.GameId, v.vote.UserId)
+	if err != nil {
+		return nil
+	}
+	// Votes placed before they could be split only have their single option
+	if len(allocations) == 0 && v.vote.OptionId != "" {
+		allocations = []models.Allocation{{
+			GameId:   v.vote.GameId,
+			UserId:   v.vote.UserId,
+			OptionId: v.vote.OptionId,
+			Money:    v.vote.Money,
+		}}
+	}
+	var allocationResolvers []*AllocationResolver
+	for index := range allocations {
+		allocationResolvers = append(allocationResolvers, &AllocationResolver{
+			allocation: &allocations[index],
+			resolved:   v.vote.Resolved,
+		})
+	}
+	return &allocationResolvers
+}
+
+func (v *VoteResolver) NUMBER(ctx context.Context) *float64 {
+	return v.vote.Number
+}
+
+func (v *VoteResolver) ANSWER(ctx context.Context) *string {
+	if v.vote.Answer == "" {
+		return nil
+	}
+	return &v.vote.Answer
+}
+
 func (v *VoteResolver) MONEY(ctx context.Context) *int32 {
 	return &v.vote.Money
 }
@@ -44,7 +76,7 @@ func (v *VoteResolver) RESULT(ctx context.Context) *VoteResultResolver {
 	if v.vote.Resolved == false {
 		return nil
 	} else {
-		return &VoteResultResolver{	v.vote.Win, v.vote.Change}
+		return &VoteResultResolver{v.vote.Win, v.vote.Change, v.vote.Number != nil, v.vote.Distance}
 	}
 
 }
\ No newline at end of file
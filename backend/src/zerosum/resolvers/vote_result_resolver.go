@@ -7,6 +7,8 @@ import (
 type VoteResultResolver struct {
 	win bool
 	change int32
+	numeric bool
+	distance float64
 }
 
 func (v *VoteResultResolver) WIN(ctx context.Context) *bool {
@@ -16,3 +18,10 @@ func (v *VoteResultResolver) WIN(ctx context.Context) *bool {
 func (v *VoteResultResolver) NETCHANGE(ctx context.Context) *int32 {
 	return &v.change
 }
+
+func (v *VoteResultResolver) DISTANCE(ctx context.Context) *float64 {
+	if !v.numeric {
+		return nil
+	}
+	return &v.distance
+}
@@ -0,0 +1,237 @@
+package tracing
+
+import (
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/segmentio/ksuid"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header keys trace and span ids are propagated in
+const (
+	TRACE_ID_HEADER = "X-Trace-Id"
+	SPAN_ID_HEADER  = "X-Span-Id"
+)
+
+// Tracer that keeps the last finished spans in memory, so that they can be looked at in tests or while debugging
+type MemoryTracer struct {
+	mu       sync.Mutex
+	limit    int
+	finished []*MemorySpan
+}
+
+// Identifies a span and the trace it belongs to
+type MemorySpanContext struct {
+	TraceId string
+	SpanId  string
+}
+
+type MemorySpan struct {
+	tracer        *MemoryTracer
+	mu            sync.Mutex
+	context       MemorySpanContext
+	ParentId      string // empty for the root span of a trace
+	OperationName string
+	StartTime     time.Time
+	FinishTime    time.Time
+	Tags          map[string]interface{}
+	Logs          []string
+	baggage       map[string]string
+}
+
+// Keeps at most limit finished spans, dropping the oldest first
+func NewMemoryTracer(limit int) *MemoryTracer {
+	return &MemoryTracer{limit: limit}
+}
+
+func newId() string {
+	return ksuid.New().String()
+}
+
+func (t *MemoryTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	options := opentracing.StartSpanOptions{}
+	for _, opt := range opts {
+		opt.Apply(&options)
+	}
+	span := &MemorySpan{
+		tracer:        t,
+		context:       MemorySpanContext{TraceId: newId(), SpanId: newId()},
+		OperationName: operationName,
+		StartTime:     options.StartTime,
+		Tags:          make(map[string]interface{}),
+		baggage:       make(map[string]string),
+	}
+	if span.StartTime.IsZero() {
+		span.StartTime = time.Now()
+	}
+	for key, value := range options.Tags {
+		span.Tags[key] = value
+	}
+	for _, ref := range options.References {
+		if parent, ok := ref.ReferencedContext.(MemorySpanContext); ok {
+			span.context.TraceId = parent.TraceId
+			span.ParentId = parent.SpanId
+			break
+		}
+	}
+	return span
+}
+
+func (t *MemoryTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	context, ok := sc.(MemorySpanContext)
+	if !ok {
+		return opentracing.ErrInvalidSpanContext
+	}
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok || (format != opentracing.TextMap && format != opentracing.HTTPHeaders) {
+		return opentracing.ErrUnsupportedFormat
+	}
+	writer.Set(TRACE_ID_HEADER, context.TraceId)
+	writer.Set(SPAN_ID_HEADER, context.SpanId)
+	return nil
+}
+
+func (t *MemoryTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok || (format != opentracing.TextMap && format != opentracing.HTTPHeaders) {
+		return nil, opentracing.ErrUnsupportedFormat
+	}
+	var context MemorySpanContext
+	err := reader.ForeachKey(func(key, value string) error {
+		// HTTP headers may have been canonicalised on the way in
+		switch {
+		case strings.EqualFold(key, TRACE_ID_HEADER):
+			context.TraceId = value
+		case strings.EqualFold(key, SPAN_ID_HEADER):
+			context.SpanId = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if context.TraceId == "" || context.SpanId == "" {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	return context, nil
+}
+
+// Spans finished so far, oldest first
+func (t *MemoryTracer) FinishedSpans() []*MemorySpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*MemorySpan(nil), t.finished...)
+}
+
+func (t *MemoryTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finished = nil
+}
+
+func (t *MemoryTracer) record(span *MemorySpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finished = append(t.finished, span)
+	if t.limit > 0 && len(t.finished) > t.limit {
+		t.finished = t.finished[len(t.finished)-t.limit:]
+	}
+}
+
+/* SPAN CONTEXT */
+func (c MemorySpanContext) ForeachBaggageItem(handler func(k, v string) bool) {}
+
+/* SPAN */
+func (s *MemorySpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *MemorySpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.mu.Lock()
+	s.FinishTime = opts.FinishTime
+	if s.FinishTime.IsZero() {
+		s.FinishTime = time.Now()
+	}
+	for _, record := range opts.LogRecords {
+		s.logFields(record.Fields...)
+	}
+	s.mu.Unlock()
+	s.tracer.record(s)
+}
+
+func (s *MemorySpan) Context() opentracing.SpanContext {
+	return s.context
+}
+
+func (s *MemorySpan) SetOperationName(operationName string) opentracing.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.OperationName = operationName
+	return s
+}
+
+func (s *MemorySpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Tags[key] = value
+	return s
+}
+
+func (s *MemorySpan) Tag(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tags[key]
+}
+
+func (s *MemorySpan) LogFields(fields ...log.Field) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logFields(fields...)
+}
+
+func (s *MemorySpan) logFields(fields ...log.Field) {
+	for _, field := range fields {
+		s.Logs = append(s.Logs, field.String())
+	}
+}
+
+func (s *MemorySpan) LogKV(alternatingKeyValues ...interface{}) {
+	fields, err := log.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		s.LogFields(log.Error(err))
+		return
+	}
+	s.LogFields(fields...)
+}
+
+func (s *MemorySpan) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.baggage[restrictedKey] = value
+	return s
+}
+
+func (s *MemorySpan) BaggageItem(restrictedKey string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baggage[restrictedKey]
+}
+
+func (s *MemorySpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (s *MemorySpan) LogEvent(event string) {
+	s.LogFields(log.String("event", event))
+}
+
+func (s *MemorySpan) LogEventWithPayload(event string, payload interface{}) {
+	s.LogFields(log.String("event", event), log.String("payload", fmt.Sprint(payload)))
+}
+
+func (s *MemorySpan) Log(data opentracing.LogData) {
+	s.LogEventWithPayload(data.Event, data.Payload)
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"strings"
+)
+
+// Sets the tracer used across the server, which is a no-op tracer until this is called
+func Init(tracer opentracing.Tracer) {
+	opentracing.SetGlobalTracer(tracer)
+}
+
+// Starts a span as a child of the span in ctx, or a new trace if there is none
+func StartSpan(ctx context.Context, operationName string) (opentracing.Span, context.Context) {
+	return opentracing.StartSpanFromContext(ctx, operationName)
+}
+
+// Finishes a span, marking it as failed if err is set
+func FinishSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.Error(err))
+	}
+	span.Finish()
+}
+
+// Id of the trace the span in ctx belongs to, for adding to logs. Empty if there is no span, or the tracer does not
+// give its traces ids.
+func TraceId(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	// Tracers only share how they propagate their span contexts, so the id is read back from the headers they set
+	carrier := opentracing.TextMapCarrier{}
+	if err := span.Tracer().Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+		return ""
+	}
+	for key, value := range carrier {
+		switch strings.ToLower(key) {
+		case strings.ToLower(TRACE_ID_HEADER), "x-b3-traceid":
+			return value
+		case "uber-trace-id":
+			// Formatted as trace-id:span-id:parent-id:flags
+			return strings.SplitN(value, ":", 2)[0]
+		case "traceparent":
+			// Formatted as version-trace-id-parent-id-flags
+			if parts := strings.Split(value, "-"); len(parts) > 1 {
+				return parts[1]
+			}
+		}
+	}
+	return ""
+}
+
+// Starts a span for each request, continuing the trace of the caller if it sent one, and sends the trace id back in
+// a header
+func NegroniMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	tracer := opentracing.GlobalTracer()
+	parent, _ := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+	span := tracer.StartSpan("HTTP "+r.Method+" "+r.URL.Path, ext.RPCServerOption(parent))
+	defer span.Finish()
+	ext.HTTPMethod.Set(span, r.Method)
+	ext.HTTPUrl.Set(span, r.URL.Path)
+
+	ctx := opentracing.ContextWithSpan(r.Context(), span)
+	if traceId := TraceId(ctx); traceId != "" {
+		w.Header().Set(TRACE_ID_HEADER, traceId)
+	}
+	next(w, r.WithContext(ctx))
+}
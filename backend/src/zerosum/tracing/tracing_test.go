@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func useMemoryTracer(t *testing.T) *MemoryTracer {
+	tracer := NewMemoryTracer(10)
+	Init(tracer)
+	t.Cleanup(func() {
+		Init(opentracing.NoopTracer{})
+	})
+	return tracer
+}
+
+func TestChildSpans(t *testing.T) {
+	tracer := useMemoryTracer(t)
+	parent, ctx := StartSpan(context.Background(), "parent")
+	child, childCtx := StartSpan(ctx, "child")
+	FinishSpan(child, errors.New("failed"))
+	FinishSpan(parent, nil)
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 || spans[0].OperationName != "child" || spans[1].OperationName != "parent" {
+		t.Fatalf("Expected child and parent spans, got %+v", spans)
+	}
+	if spans[0].ParentId != spans[1].Context().(MemorySpanContext).SpanId {
+		t.Errorf("Expected child span to be a child of parent span")
+	}
+	if TraceId(childCtx) == "" || TraceId(childCtx) != TraceId(ctx) {
+		t.Errorf("Expected child span to be in the trace of its parent")
+	}
+	if spans[0].Tag("error") != true || spans[1].Tag("error") != nil {
+		t.Errorf("Expected only failed span to be marked as an error")
+	}
+	if TraceId(context.Background()) != "" {
+		t.Errorf("Expected no trace id without a span")
+	}
+}
+
+func TestSpanLimit(t *testing.T) {
+	tracer := NewMemoryTracer(2)
+	for _, name := range []string{"first", "second", "third"} {
+		tracer.StartSpan(name).Finish()
+	}
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 || spans[0].OperationName != "second" {
+		t.Errorf("Expected oldest span to be dropped, got %+v", spans)
+	}
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	tracer := useMemoryTracer(t)
+	caller := tracer.StartSpan("caller")
+	req := httptest.NewRequest("POST", "/gql", nil)
+	tracer.Inject(caller.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+
+	var traceId string
+	rec := httptest.NewRecorder()
+	NegroniMiddleware(rec, req, func(w http.ResponseWriter, r *http.Request) {
+		traceId = TraceId(r.Context())
+	})
+	if traceId != caller.Context().(MemorySpanContext).TraceId {
+		t.Errorf("Expected request to continue the caller's trace, got %q", traceId)
+	}
+	if rec.Header().Get(TRACE_ID_HEADER) != traceId {
+		t.Errorf("Expected trace id in response header, got %q", rec.Header().Get(TRACE_ID_HEADER))
+	}
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 || spans[0].OperationName != "HTTP POST /gql" {
+		t.Errorf("Expected span for request, got %+v", spans)
+	}
+}
+
+// No-op tracer that propagates its spans in the given header, as other tracers would
+type headerTracer struct {
+	opentracing.NoopTracer
+	key   string
+	value string
+}
+
+type headerSpan struct {
+	opentracing.Span
+	tracer headerTracer
+}
+
+func (t headerTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	return headerSpan{Span: t.NoopTracer.StartSpan(operationName, opts...), tracer: t}
+}
+
+func (s headerSpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (t headerTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	carrier.(opentracing.TextMapWriter).Set(t.key, t.value)
+	return nil
+}
+
+func TestTraceIdOfOtherTracers(t *testing.T) {
+	tests := []struct {
+		tracer   opentracing.Tracer
+		expected string
+	}{
+		{opentracing.NoopTracer{}, ""},
+		{headerTracer{key: "X-B3-TraceId", value: "463ac35c9f6413ad"}, "463ac35c9f6413ad"},
+		{headerTracer{key: "uber-trace-id", value: "463ac35c9f6413ad:a2fb4a1d1a96d312:0:1"}, "463ac35c9f6413ad"},
+		{headerTracer{key: "traceparent", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			"4bf92f3577b34da6a3ce929d0e0e4736"},
+		{headerTracer{key: "unknown", value: "id"}, ""},
+	}
+	for _, test := range tests {
+		ctx := opentracing.ContextWithSpan(context.Background(), test.tracer.StartSpan("span"))
+		if traceId := TraceId(ctx); traceId != test.expected {
+			t.Errorf("Expected trace id %q with %T, got %q", test.expected, test.tracer, traceId)
+		}
+	}
+}
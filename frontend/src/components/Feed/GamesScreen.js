@@ -26,27 +26,31 @@ import CircularProgress from "@material-ui/core/CircularProgress";
 import AngryHatperor from "../assets/angry-hatperor.png";
 
 const GET_ACTIVE_GAMES = gql`
-  query GetActiveGames($filter: String!, $joined: Boolean, $created: Boolean, $limit: Int) {
-    activeGames(filter: $filter, joined: $joined, created: $created, limit: $limit) {
-      id
-      owner {
-        name
-        img
-      }
-      topic
-      endTime
-      totalMoney
-      resolved
-      voted
-      stakes
-      gameMode
-      options {
-        id
-        body
-        result {
-          voteCount
-          totalValue
-          winner
+  query GetActiveGames($filter: String!, $joined: Boolean, $created: Boolean, $first: Int) {
+    activeGames(filter: $filter, joined: $joined, created: $created, first: $first) {
+      edges {
+        node {
+          id
+          owner {
+            name
+            img
+          }
+          topic
+          endTime
+          totalMoney
+          resolved
+          voted
+          stakes
+          gameMode
+          options {
+            id
+            body
+            result {
+              voteCount
+              totalValue
+              winner
+            }
+          }
         }
       }
     }
@@ -54,33 +58,42 @@ const GET_ACTIVE_GAMES = gql`
 `;
 
 const GET_COMPLETED_GAMES = gql`
-  query GetCompletedGames($created: Boolean!) {
-    completedGames(created: $created) {
-      id
-      owner {
-        name
-        img
-      }
-      topic
-      endTime
-      totalMoney
-      resolved
-      voted
-      stakes
-      gameMode
-      options {
-        id
-        body
-        result {
-          voteCount
-          totalValue
-          winner
+  query GetCompletedGames($created: Boolean!, $first: Int) {
+    completedGames(created: $created, first: $first) {
+      edges {
+        node {
+          id
+          owner {
+            name
+            img
+          }
+          topic
+          endTime
+          totalMoney
+          resolved
+          voted
+          stakes
+          gameMode
+          options {
+            id
+            body
+            result {
+              voteCount
+              totalValue
+              winner
+            }
+          }
         }
       }
     }
   }
 `;
 
+// Largest page the server returns, the feed shows a single page of each list
+const PAGE_SIZE = 100;
+
+const nodes = connection => connection ? connection.edges.map(edge => edge.node) : [];
+
 const GET_COUNT = gql`
   {
     gameCount
@@ -283,7 +296,7 @@ class GamesScreen extends Component {
           </Tabs>
         </AppBar>
         {value === 0 &&
-        <Query query={GET_ACTIVE_GAMES} variables={{filter: this.state.search, joined: false}} fetchPolicy="network-only">
+        <Query query={GET_ACTIVE_GAMES} variables={{filter: this.state.search, joined: false, first: PAGE_SIZE}} fetchPolicy="network-only">
           {({loading, error, data}) => {
             if (loading) {
               return (
@@ -305,8 +318,7 @@ class GamesScreen extends Component {
                 </Paper>
               );
             } else {
-              let games = data.activeGames;
-              if (games === undefined) games = [];
+              let games = nodes(data.activeGames);
               if (this.state.sortState) {
                 games = this.applySort(games);
               }
@@ -316,14 +328,14 @@ class GamesScreen extends Component {
         </Query>
         }
         {value === 1 &&
-        <Query query={GET_ACTIVE_GAMES} variables={{filter: this.state.search, created: true}} fetchPolicy="network-only">
+        <Query query={GET_ACTIVE_GAMES} variables={{filter: this.state.search, created: true, first: PAGE_SIZE}} fetchPolicy="network-only">
           {({loading: loadingOne, error: errorOne, data: createdActive}) => (
-            <Query query={GET_ACTIVE_GAMES} variables={{filter: this.state.search, joined: true, created: false}}
+            <Query query={GET_ACTIVE_GAMES} variables={{filter: this.state.search, joined: true, created: false, first: PAGE_SIZE}}
                    fetchPolicy="network-only">
               {({loading: loadingTwo, error: errorTwo, data: joinedActive}) => (
-                <Query query={GET_COMPLETED_GAMES} variables={{created: true}} fetchPolicy="network-only">
+                <Query query={GET_COMPLETED_GAMES} variables={{created: true, first: PAGE_SIZE}} fetchPolicy="network-only">
                   {({loading: loadingThree, error: errorThree, data: createdResolved}) => (
-                    <Query query={GET_COMPLETED_GAMES} variables={{created: false}} fetchPolicy="network-only">
+                    <Query query={GET_COMPLETED_GAMES} variables={{created: false, first: PAGE_SIZE}} fetchPolicy="network-only">
                       {({loading: loadingFour, error: errorFour, data: joinedResolved}) => {
                         if (loadingOne || loadingTwo || loadingThree || loadingFour) {
                           return (
@@ -345,12 +357,8 @@ class GamesScreen extends Component {
                             </Paper>
                           );
                         } else {
-                          if (createdActive === undefined) createdActive = [];
-                          if (joinedActive === undefined) joinedActive = [];
-                          if (createdResolved === undefined) createdResolved = [];
-                          if (joinedResolved === undefined) joinedResolved = [];
-                          let games = createdActive.activeGames.concat(joinedActive.activeGames,
-                            createdResolved.completedGames, joinedResolved.completedGames);
+                          let games = nodes(createdActive.activeGames).concat(nodes(joinedActive.activeGames),
+                            nodes(createdResolved.completedGames), nodes(joinedResolved.completedGames));
                           if (this.state.sortState) {
                             games = this.applySort(games);
                           }
@@ -15,11 +15,15 @@ import AngryHatperor from "../assets/angry-hatperor.png";
 
 
 const GET_LEADERBOARD = gql`
-  query GetLeaderboard($limit: Int!) {
-    leaderboard(limit: $limit) {
-      name
-      img
-      winRate
+  query GetLeaderboard($first: Int) {
+    leaderboard(first: $first) {
+      edges {
+        node {
+          name
+          img
+          winRate
+        }
+      }
     }
   }
 `;
@@ -113,7 +117,7 @@ class GlobalLeaderboard extends Component {
   render() {
     const {classes} = this.props;
     return (
-      <Query query={GET_LEADERBOARD} variables={{limit: 10}} fetchPolicy="cache-and-network" errorPolicy="ignore">
+      <Query query={GET_LEADERBOARD} variables={{first: 10}} fetchPolicy="cache-and-network" errorPolicy="ignore">
         {({loading, error, data}) => {
           if (loading) return (
             <Paper elevation={0} className={classes.body}>
@@ -133,7 +137,7 @@ class GlobalLeaderboard extends Component {
             </Paper>
           );
 
-          const leaders = data.leaderboard;
+          const leaders = data.leaderboard && data.leaderboard.edges.map(edge => edge.node);
           return (
             <Paper elevation={0} className={classes.body}>
               {